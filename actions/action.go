@@ -25,19 +25,94 @@
 package actions
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
+const (
+	// The version of the hash format, every hash starts with the version followed by a colon
+	HashVersion = "v1"
+	// The tags of the fields in the canonical encoding
+	tagPlugin = 'p'
+	tagName   = 'n'
+	tagArg    = 'a'
+	tagDir    = 'd'
+	tagEnv    = 'e'
+	tagStdin  = 'i'
+)
+
 // The command that should be executed.
 type Command struct {
 	// The command
 	Name string `json:"name"`
 	// The arguments for the command
 	Args []string `json:"args,omitempty"`
+	// The absolute path of the directory the command runs in, the working directory of the hook if empty
+	Dir string `json:"dir,omitempty"`
+	// Environment variables that are set for the command, by name
+	Env map[string]string `json:"env,omitempty"`
+	// Written to the standard input of the command, the command reads no input if empty
+	Stdin string `json:"stdin,omitempty"`
+}
+
+// Checks that the command can be executed as it was approved: it has a name, the directory is absolute and clean and
+// the environment variables have valid names. Values can not contain NUL characters, they would be cut off.
+func (c *Command) Validate() error {
+	if c.Name == "" {
+		return errors.New("command has no name")
+	}
+	if strings.ContainsRune(c.Name, 0) {
+		return errors.New("command name contains a NUL character")
+	}
+	for i, arg := range c.Args {
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("argument %d contains a NUL character", i+1)
+		}
+	}
+	if c.Dir != "" && (!filepath.IsAbs(c.Dir) || filepath.Clean(c.Dir) != c.Dir || strings.ContainsRune(c.Dir, 0)) {
+		return fmt.Errorf("directory %q is not a clean absolute path", c.Dir)
+	}
+	for name, value := range c.Env {
+		if !ValidEnvName(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("environment variable %s contains a NUL character", name)
+		}
+	}
+	return nil
+}
+
+// Whether name is a portable environment variable name: letters, digits and underscores, not starting with a digit.
+func ValidEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		letter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the environment variables of the command as NAME=value, sorted by name.
+func (c *Command) Environ() []string {
+	env := make([]string, 0, len(c.Env))
+	for name, value := range c.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env) // Names can not contain '=', so this sorts by name
+	return env
 }
 
 func (c *Command) String() string {
@@ -46,21 +121,102 @@ func (c *Command) String() string {
 	}
 	return fmt.Sprintf("%s %s", c.Name, strings.Join(c.Args, " "))
 }
+
+// The executable that the name of a command resolved to on this machine.
+type Executable struct {
+	// The absolute path of the executable
+	Path string `json:"path"`
+	// The hex encoded sha256 of the contents of the executable
+	Digest string `json:"digest,omitempty"`
+}
+
+// Whether e and o are the same executable, two nil executables are equal.
+func (e *Executable) Equal(o *Executable) bool {
+	if e == nil || o == nil {
+		return e == o
+	}
+	return *e == *o
+}
+
+func (e *Executable) String() string {
+	if e.Digest == "" {
+		return e.Path
+	}
+	return fmt.Sprintf("%s (sha256 %s)", e.Path, e.Digest)
+}
+
 // An action is the intent by a plugin to execute a command.
 type Action struct {
 	// The command to execute
 	Command Command `json:"command"`
 	// The plugin that supposedly executed this action
 	Plugin string `json:"plugin"`
+	// The executable that will run the command, this is resolved by the hook and never sent by a plugin. It is not part
+	// of the hash: a stored policy records the executable it was set for instead, see the storage package.
+	Executable *Executable `json:"executable,omitempty"`
+	// The names of the variables in the environment of the hook that are passed to the command, sorted. Like the
+	// executable, these are set by the hook and are not part of the hash.
+	PassEnv []string `json:"passEnv,omitempty"`
+}
+
+// Returns the canonical encoding of the action, this is what the hash is computed over. Every field is encoded as a
+// one byte tag, followed by the length of its value as an 8 byte big endian integer and the value itself:
+//	'p' the plugin
+//	'n' the command name
+//	'a' an argument, once for every argument in order
+//	'd' the directory, only if set
+//	'e' an environment variable as NAME=value, once for every variable sorted by name
+//	'i' the standard input, only if set
+// Fields are encoded in this order. Fields that are added to actions later are only encoded when they are set, so the
+// encoding and hash of existing actions never change.
+func (a Action) Canonical() []byte {
+	var buf bytes.Buffer
+	writeField(&buf, tagPlugin, a.Plugin)
+	writeField(&buf, tagName, a.Command.Name)
+	for _, arg := range a.Command.Args {
+		writeField(&buf, tagArg, arg)
+	}
+	if a.Command.Dir != "" {
+		writeField(&buf, tagDir, a.Command.Dir)
+	}
+	for _, env := range a.Command.Environ() {
+		writeField(&buf, tagEnv, env)
+	}
+	if a.Command.Stdin != "" {
+		writeField(&buf, tagStdin, a.Command.Stdin)
+	}
+	return buf.Bytes()
 }
 
-// Gets the unique hash of this action. This is achieved by encoding the struct as json and getting the base64 of the sha256sum of this json.
-// Note that as this uses the json parser, these hashes may not necessarily be the same across go versions. There should however never be collisions.
+// Writes a single field of the canonical encoding.
+func writeField(buf *bytes.Buffer, tag byte, value string) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+	buf.WriteByte(tag)
+	buf.Write(length[:])
+	buf.WriteString(value)
+}
+
+// Gets the unique hash of this action: HashVersion and a colon, followed by the unpadded base64url encoding of the
+// sha256 of the canonical encoding (see Canonical). The hash of "git status" by "my-plugin" is
+// v1:pNrAemxIBnzcJFWPlE25WWWxhj0mNC5C7KoHDrd2M-s.
 func (a Action) Hash() string {
-	bytes, err := json.Marshal(a)
-	if err != nil {
-		panic(err)
+	sum := sha256.Sum256(a.Canonical())
+	return HashVersion + ":" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Recovers the action from a hash in the format that was used before HashVersion, which was not a digest at all: it
+// was the base64 of the JSON encoding of the action followed by the sha256 of nothing. Returns false if the hash is not
+// in that format.
+func ParseLegacyHash(hash string) (Action, bool) {
+	data, err := base64.StdEncoding.DecodeString(hash)
+	empty := sha256.Sum256(nil)
+	if err != nil || len(data) < len(empty) || !bytes.Equal(data[len(data)-len(empty):], empty[:]) {
+		return Action{}, false
+	}
+	var a Action
+	if err := json.Unmarshal(data[:len(data)-len(empty)], &a); err != nil {
+		return Action{}, false
 	}
-	hash := sha256.New()
-	return base64.StdEncoding.EncodeToString(hash.Sum(bytes))
+	return a, true
 }
@@ -24,7 +24,13 @@
 
 package actions
 
-import "testing"
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
 
 func TestString(t *testing.T) {
 	c := Command{Name: "Test"}
@@ -68,6 +74,48 @@ func TestCollisionArgs(t *testing.T) {
 	}
 }
 
+// Pins the hash format: a hash must never change for an existing action, or stored decisions are lost.
+func TestHashStable(t *testing.T) {
+	a := Action{Plugin: "my-plugin", Command: Command{Name: "git", Args: []string{"status"}}}
+	if a.Hash() != "v1:pNrAemxIBnzcJFWPlE25WWWxhj0mNC5C7KoHDrd2M-s" {
+		t.Error("the hash of an action changed: ", a.Hash())
+	}
+	if !strings.HasPrefix(a.Hash(), HashVersion+":") {
+		t.Error("hash does not start with its version")
+	}
+}
+
+// Makes sure that values can not be shifted between fields to get the same hash.
+func TestHashFieldBoundaries(t *testing.T) {
+	pairs := [][2]Action{
+		{{Plugin: "ab", Command: Command{Name: "c"}}, {Plugin: "a", Command: Command{Name: "bc"}}},
+		{{Command: Command{Name: "a", Args: []string{"b c"}}}, {Command: Command{Name: "a", Args: []string{"b", "c"}}}},
+		{{Command: Command{Name: "a", Args: []string{""}}}, {Command: Command{Name: "a"}}},
+	}
+	for _, p := range pairs {
+		if p[0].Hash() == p[1].Hash() {
+			t.Error("different actions have the same hash: ", p[0], p[1])
+		}
+	}
+}
+
+// Makes sure that actions are recovered from hashes in the legacy format.
+func TestParseLegacyHash(t *testing.T) {
+	a := Action{Plugin: "test", Command: Command{Name: "git", Args: []string{"status"}}}
+	data, _ := json.Marshal(a)
+	legacy := base64.StdEncoding.EncodeToString(sha256.New().Sum(data))
+
+	parsed, ok := ParseLegacyHash(legacy)
+	if !ok || parsed.Hash() != a.Hash() {
+		t.Error("action was not recovered from the legacy hash: ", parsed)
+	}
+	for _, invalid := range []string{a.Hash(), "not base64!", base64.StdEncoding.EncodeToString(data)} {
+		if _, ok := ParseLegacyHash(invalid); ok {
+			t.Error("parsed a hash that is not in the legacy format: ", invalid)
+		}
+	}
+}
+
 var samples = []string{"", "test", "tests", "t", "{\"name\":\"test\"}", "Test", "*"}
 
 // Generates a set of unique actions.
@@ -86,3 +134,76 @@ func generateUniqueActions() []Action {
 	}
 	return actions
 }
+
+// Makes sure that the executable is not part of the hash and that executables are compared by value.
+func TestExecutable(t *testing.T) {
+	a := Action{Plugin: "my-plugin", Command: Command{Name: "git"}}
+	b := a
+	b.Executable = &Executable{Path: "/usr/bin/git", Digest: "1234"}
+	if a.Hash() != b.Hash() {
+		t.Error("the executable changed the hash")
+	}
+	if !a.Executable.Equal(nil) || a.Executable.Equal(b.Executable) || b.Executable.Equal(nil) {
+		t.Error("nil executables were not compared correctly")
+	}
+	if !b.Executable.Equal(&Executable{Path: "/usr/bin/git", Digest: "1234"}) || b.Executable.Equal(&Executable{Path: "/usr/bin/git"}) {
+		t.Error("executables were not compared by value")
+	}
+}
+
+// Makes sure that the directory, environment and input are part of the hash, without changing the hash of actions
+// that do not set them.
+func TestHashContext(t *testing.T) {
+	a := Action{Plugin: "my-plugin", Command: Command{Name: "git", Args: []string{"status"}}}
+	plain := a.Hash()
+	a.Command.Env = map[string]string{}
+	if a.Hash() != plain {
+		t.Error("an empty environment changed the hash")
+	}
+	hashes := map[string]bool{plain: true}
+	for _, c := range []Command{
+		{Name: "git", Args: []string{"status"}, Dir: "/src"},
+		{Name: "git", Args: []string{"status"}, Env: map[string]string{"A": "b"}},
+		{Name: "git", Args: []string{"status"}, Env: map[string]string{"A": "b", "C": "d"}},
+		{Name: "git", Args: []string{"status"}, Stdin: "/src"},
+		{Name: "git", Args: []string{"status", "/src"}},
+	} {
+		hashes[Action{Plugin: "my-plugin", Command: c}.Hash()] = true
+	}
+	if len(hashes) != 6 {
+		t.Error("expected 6 unique hashes, got ", len(hashes))
+	}
+	env := map[string]string{}
+	for _, name := range []string{"Z", "A", "M", "B"} {
+		env[name] = name
+	}
+	first := Action{Command: Command{Name: "x", Env: env}}.Hash()
+	for i := 0; i < 10; i++ {
+		if (Action{Command: Command{Name: "x", Env: env}}).Hash() != first {
+			t.Fatal("the hash depends on the order of the environment")
+		}
+	}
+}
+
+func TestCommandValidate(t *testing.T) {
+	valid := Command{Name: "git", Args: []string{"status"}, Dir: "/home/user/src", Env: map[string]string{"GIT_PAGER": "", "_X1": "a b"}, Stdin: "input"}
+	if err := valid.Validate(); err != nil {
+		t.Error("valid command is invalid: ", err)
+	}
+	invalid := []Command{
+		{},
+		{Name: "git\x00rm"},
+		{Name: "git", Args: []string{"a\x00"}},
+		{Name: "git", Dir: "src"},
+		{Name: "git", Dir: "/home/user/../root"},
+		{Name: "git", Env: map[string]string{"1A": "b"}},
+		{Name: "git", Env: map[string]string{"A=B": "c"}},
+		{Name: "git", Env: map[string]string{"": "c"}},
+		{Name: "git", Env: map[string]string{"A": "\x00"}},
+	}
+	for _, c := range invalid {
+		if c.Validate() == nil {
+			t.Errorf("command %q should be invalid", c)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/audit"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/config"
+	"github.com/tcorp-bv/backstage-hook/storage"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// The file name of the audit log in the storage directory
+	auditFile = "audit.log"
+)
+
+// Manages the audit log: backstage-hook audit verify [file]
+var auditCommand = &cli.Command{
+	Name:     "audit",
+	Usage:    "Inspect the audit log of all decisions and executions",
+	Commands: []*cli.Command{auditVerifyCommand},
+}
+
+// Walks the hash chain of the audit log and reports the first broken link.
+var auditVerifyCommand = &cli.Command{
+	Name:  "verify",
+	Usage: "Check the hash chain of the audit log (default: audit.log in the storage directory)",
+	Flags: config.CliFlags("storage", "audit"),
+	Args:  []cli.Arg{{Name: "file", Optional: true}},
+	Handler: func(c *cli.Context) error {
+		path := c.Arg("file")
+		if path == "" {
+			cfg, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			if path, err = auditPath(cfg); err != nil {
+				return err
+			}
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		res, err := audit.Verify(f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "%s is intact: %d entries, head %s\n", path, res.Entries, res.Head)
+		return nil
+	},
+}
+
+// Returns the configured audit log, or audit.log in the configured storage directory if there is none.
+func auditPath(cfg *config.Config) (string, error) {
+	if cfg.Audit != "" {
+		return cfg.Audit, nil
+	}
+	dir := cfg.Storage
+	if dir == "" {
+		var err error
+		if dir, err = storage.DefaultDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, auditFile), nil
+}
@@ -0,0 +1,237 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package audit
+
+// The audit package keeps an append-only log of every action the hook received, the decision on it and the outcome of
+// its execution, and of the requests it rejected. Every entry contains the hash of the previous line, so an entry that
+// was edited or deleted breaks the chain and is detected by Verify. Truncating the end of the log can only be detected
+// by comparing the head hash returned by Verify with a copy stored elsewhere.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The kind of event an entry records.
+type Kind string
+
+const (
+	// A decision on an action that arrived
+	KindDecision Kind = "decision"
+	// The outcome of an executed action
+	KindExecution Kind = "execution"
+	// A request that was rejected before its action was decided on, eg. because of an invalid signature, a replayed
+	// nonce, a foreign origin or because it was cancelled before the decision
+	KindRejection Kind = "rejection"
+)
+
+// Replaces the values of the environment variables and the input of a recorded action, they may contain secrets. The
+// hash of the action still identifies the values.
+const Redacted = "REDACTED"
+
+// Where the applied policy came from.
+type Source string
+
+const (
+	// The user decided through the UI
+	SourcePrompt Source = "prompt"
+	// A policy stored for this exact action decided (eg. allow always)
+	SourceStored Source = "stored"
+	// A pattern based rule decided
+	SourceRule Source = "rule"
+)
+
+// The outcome of an executed action.
+type Outcome struct {
+	// The exit code of the command
+	ExitCode int `json:"exitCode"`
+	// How the command terminated (eg. exited, timeout, cancelled)
+	Status string `json:"status,omitempty"`
+	// The signal that killed the command
+	Signal string `json:"signal,omitempty"`
+	// Set if the command could not be executed
+	Error string `json:"error,omitempty"`
+}
+
+// A single record in the audit log.
+type Entry struct {
+	// The position in the log, starting at 1. Set by Append.
+	Seq uint64 `json:"seq"`
+	// The time the entry was appended. Set by Append.
+	Time time.Time `json:"time"`
+	// The hash of the previous line in the log, empty for the first entry. Set by Append.
+	Prev string `json:"prev"`
+	// What this entry records
+	Kind Kind `json:"kind"`
+	// The hash of the action, this correlates the decision and execution entries of an action
+	ActionHash string `json:"actionHash"`
+	// The action with its environment values and input redacted (see Redact), only set on decision entries and on
+	// rejections of a cancelled action
+	Action *actions.Action `json:"action,omitempty"`
+	// The id of the session that sent the action
+	Session string `json:"session,omitempty"`
+	// The id of the applied policy
+	Policy string `json:"policy,omitempty"`
+	// Where the policy came from
+	Source Source `json:"source,omitempty"`
+	// Who decided: the name of the local user for prompts, the rule id for rules
+	Decider string `json:"decider,omitempty"`
+	// The outcome of the execution, only set on execution entries
+	Outcome *Outcome `json:"outcome,omitempty"`
+	// Why the request was rejected, only set on rejection entries
+	Reason string `json:"reason,omitempty"`
+}
+
+// Returns a copy of the action to record, the names of its environment variables are kept but their values and the
+// input are replaced by Redacted.
+func Redact(a actions.Action) *actions.Action {
+	if len(a.Command.Env) > 0 {
+		env := make(map[string]string, len(a.Command.Env))
+		for name := range a.Command.Env {
+			env[name] = Redacted
+		}
+		a.Command.Env = env
+	}
+	if a.Command.Stdin != "" {
+		a.Command.Stdin = Redacted
+	}
+	return &a
+}
+
+// Returns the hash that the next entry stores as Prev.
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// An audit log file that is only appended to. A log should only be opened by a single process at a time.
+type Log struct {
+	sync.Mutex
+	file *os.File
+	// The hash of the last line
+	prev string
+	// The sequence number of the last entry
+	seq uint64
+}
+
+// Opens the audit log at path for appending, the file is created if it does not exist. Returns an error if the
+// existing log is broken, so new entries are never chained to a log that was tampered with.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	res, err := Verify(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &Log{file: f, prev: res.Head, seq: uint64(res.Entries)}, nil
+}
+
+// Appends the entry to the log and flushes it to disk. Seq, Time and Prev are set by Append.
+func (l *Log) Append(e Entry) error {
+	l.Lock()
+	defer l.Unlock()
+	e.Seq, e.Time, e.Prev = l.seq+1, time.Now().UTC(), l.prev
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq, l.prev = e.Seq, hashLine(line)
+	return nil
+}
+
+// Closes the log file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// The result of a successful verification.
+type VerifyResult struct {
+	// The number of entries in the log
+	Entries int
+	// The hash of the last line, store it elsewhere to detect truncation later
+	Head string
+}
+
+// Returned by Verify for the first entry that does not link to the entry before it.
+type BrokenLinkError struct {
+	// The line number of the broken entry, starting at 1
+	Line int
+	// Why the link is broken
+	Reason string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("audit log is broken at line %d: %s", e.Line, e.Reason)
+}
+
+// Walks the chain of entries in r and returns a *BrokenLinkError for the first broken link.
+func Verify(r io.Reader) (VerifyResult, error) {
+	var res VerifyResult
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return res, nil
+		}
+		if err != nil && err != io.EOF {
+			return res, err
+		}
+		lineNumber := res.Entries + 1
+		if err == io.EOF { // Every entry is terminated by a newline
+			return res, &BrokenLinkError{Line: lineNumber, Reason: "incomplete entry"}
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return res, &BrokenLinkError{Line: lineNumber, Reason: "entry is not valid JSON"}
+		}
+		if e.Seq != uint64(lineNumber) {
+			return res, &BrokenLinkError{Line: lineNumber, Reason: fmt.Sprintf("expected entry %d, found entry %d", lineNumber, e.Seq)}
+		}
+		if e.Prev != res.Head {
+			return res, &BrokenLinkError{Line: lineNumber, Reason: "the previous entry was modified or removed"}
+		}
+		res.Entries, res.Head = lineNumber, hashLine(line)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package audit
+
+import (
+	"bytes"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates a log with n entries in a temporary directory and returns its path.
+func writeLog(t *testing.T, n int) (string, func()) {
+	dir, err := ioutil.TempDir("", "backstage-hook-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "ls"}}
+	for i := 0; i < n; i++ {
+		e := Entry{Kind: KindDecision, ActionHash: a.Hash(), Action: &a, Policy: "ALLOW", Source: SourcePrompt, Decider: "user"}
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// Reads the lines of the log.
+func readLines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.SplitAfter(string(data), "\n")
+}
+
+// Verifies the given lines and returns the line of the first broken link, 0 if the log is intact.
+func brokenLine(t *testing.T, lines []string) int {
+	_, err := Verify(strings.NewReader(strings.Join(lines, "")))
+	if err == nil {
+		return 0
+	}
+	broken, ok := err.(*BrokenLinkError)
+	if !ok {
+		t.Fatal("unexpected error ", err)
+	}
+	return broken.Line
+}
+
+func TestVerifyIntact(t *testing.T) {
+	path, remove := writeLog(t, 5)
+	defer remove()
+
+	data, _ := ioutil.ReadFile(path)
+	res, err := Verify(bytes.NewReader(data))
+	if err != nil || res.Entries != 5 || res.Head == "" {
+		t.Error("intact log did not verify: ", res, err)
+	}
+	if res, err := Verify(strings.NewReader("")); err != nil || res.Entries != 0 {
+		t.Error("empty log should be intact")
+	}
+}
+
+// Makes sure that reopening a log continues the chain.
+func TestReopen(t *testing.T) {
+	path, remove := writeLog(t, 2)
+	defer remove()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Entry{Kind: KindExecution, ActionHash: "hash", Outcome: &Outcome{ExitCode: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if line := brokenLine(t, readLines(t, path)); line != 0 {
+		t.Error("reopened log is broken at line ", line)
+	}
+}
+
+// Makes sure that edits, deletions and reordering are detected at the first broken link.
+func TestVerifyTampered(t *testing.T) {
+	path, remove := writeLog(t, 5)
+	defer remove()
+	lines := readLines(t, path)
+
+	edited := append([]string{}, lines...)
+	edited[1] = strings.Replace(edited[1], `"policy":"ALLOW"`, `"policy":"DENY"`, 1)
+	if line := brokenLine(t, edited); line != 3 {
+		t.Error("edited entry was detected at line ", line, ", expected 3")
+	}
+
+	deleted := append(append([]string{}, lines[:2]...), lines[3:]...)
+	if line := brokenLine(t, deleted); line != 3 {
+		t.Error("deleted entry was detected at line ", line, ", expected 3")
+	}
+
+	swapped := append([]string{}, lines...)
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	if line := brokenLine(t, swapped); line != 2 {
+		t.Error("swapped entries were detected at line ", line, ", expected 2")
+	}
+
+	if line := brokenLine(t, append(append([]string{}, lines[:4]...), "garbage\n")); line != 5 {
+		t.Error("invalid entry was detected at line ", line, ", expected 5")
+	}
+}
+
+// Makes sure that a broken log can not be opened for appending.
+func TestOpenBroken(t *testing.T) {
+	path, remove := writeLog(t, 3)
+	defer remove()
+
+	lines := readLines(t, path)
+	if err := ioutil.WriteFile(path, []byte(lines[0]+lines[2]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("opening a broken log should fail")
+	}
+}
+
+// Makes sure that the values of environment variables and the input are redacted without changing the action.
+func TestRedact(t *testing.T) {
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "login", Args: []string{"--user", "me"}, Env: map[string]string{"TOKEN": "secret"}, Stdin: "password"}}
+	r := Redact(a)
+	if r.Command.Env["TOKEN"] != Redacted || r.Command.Stdin != Redacted || len(r.Command.Env) != 1 {
+		t.Error("the environment values and the input are not redacted: ", r)
+	}
+	if r.Command.Name != "login" || strings.Join(r.Command.Args, " ") != "--user me" || r.Plugin != "test" {
+		t.Error("the command is not recorded: ", r)
+	}
+	if a.Command.Env["TOKEN"] != "secret" {
+		t.Error("the original action was changed")
+	}
+	if r := Redact(actions.Action{Plugin: "test", Command: actions.Command{Name: "ls"}}); r.Command.Env != nil || r.Command.Stdin != "" {
+		t.Error("an action without environment and input is changed: ", r)
+	}
+}
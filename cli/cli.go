@@ -28,11 +28,16 @@ package cli
 // Inspired by https://github.com/urfave/cli
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
 )
 
 const (
@@ -50,6 +55,8 @@ const (
 	BlueColor Color = "\033[1;34m"
 	// Ansi code for formatting text Red with fmt
 	RedColor Color = "\033[1;31m"
+	// Ansi code for formatting text Magenta with fmt
+	MagentaColor Color = "\033[1;35m"
 	// Ansi code for formatting text White with fmt
 	WhiteColor Color = "\033[1;37m"
 	// Save the cursor location
@@ -120,6 +127,8 @@ type App struct {
 	Version string
 	// List of the commands to execute
 	Commands []*Command
+	// The flags that are given before the command, they are available to every command
+	Flags []Flag
 	// Author of this application
 	Author string
 
@@ -131,10 +140,17 @@ type App struct {
 
 	// ErrWritter to write error output to
 	ErrWriter io.Writer
+
+	// Reads the lines of Reader for GetInput, started on the first call
+	inputOnce sync.Once
+	input     *lineReader
+	// Reads the keys of Reader for GetKey, started on the first call
+	keysOnce sync.Once
+	keys     *keyReader
 }
 
-// Handler is the actual handler when the user executes a command in the cli
-type Handler func(a *App, args []string) error
+// Handler is the actual handler when the user executes a command in the cli, c holds the parsed flags and arguments
+type Handler func(c *Context) error
 
 // Represents a command that the user executes in the cli (eg. when the user types backstage-hook start, start is the command)
 type Command struct {
@@ -142,71 +158,206 @@ type Command struct {
 	Name string
 	// The usage explanation
 	Usage string
-	// The command handler that manages the actual behavior of the command
+	// The flags of the command, they may be given before, between and after the arguments
+	Flags []Flag
+	// The arguments of the command, in order. Required arguments must come before optional ones.
+	Args []Arg
+	// The subcommands (eg. list in backstage-hook policy list), the Handler runs if none of them is given
+	Commands []*Command
+	// Returns the candidates to complete the next argument of the command in a shell, c holds the flags and the
+	// arguments that were given before it. A candidate may be followed by a tab and its description. Files are
+	// completed if nil.
+	Complete func(c *Context) []string
+	// The command handler that manages the actual behavior of the command, the help is shown if nil
 	Handler Handler
 }
 
+// A positional argument of a command.
+type Arg struct {
+	// The name of the argument in the help, eg. file
+	Name string
+	// Whether the command can run without the argument
+	Optional bool
+}
+
 // Prints the help menu to the app's writer (terminal)
 func (a *App) Help() {
-	fmt.Fprintf(a.Writer, "NAME:\n    %s - %s\n\n", a.Name, a.Usage)
-	fmt.Fprintf(a.Writer, "USAGE:\n    %s\n\n", a.ArgsUsage)
-	fmt.Fprintf(a.Writer, "COMMANDS:\n")
-	for _, c := range a.Commands {
-		fmt.Fprintf(a.Writer, "    %s  %s\n", c.Name, c.Usage)
+	a.help(a.root(), a.Name)
+}
+
+// Returns the app as a command, the commands of the app are its subcommands.
+func (a *App) root() *Command {
+	return &Command{Name: a.Name, Usage: a.Usage, Flags: a.Flags, Commands: a.Commands}
+}
+
+// Prints the help of the command with the given full name.
+func (a *App) help(c *Command, name string) {
+	usage := name + " " + usageLine(c)
+	if c.Name == a.Name && a.ArgsUsage != "" {
+		usage = a.ArgsUsage
+	}
+	fmt.Fprintf(a.Writer, "NAME:\n    %s - %s\n\n", name, c.Usage)
+	fmt.Fprintf(a.Writer, "USAGE:\n    %s\n", usage)
+	w := tabwriter.NewWriter(a.Writer, 0, 4, 2, ' ', 0)
+	if len(c.Commands) > 0 {
+		fmt.Fprintf(w, "\nCOMMANDS:\n")
+		for _, sub := range c.Commands {
+			fmt.Fprintf(w, "    %s\t%s\n", strings.TrimSpace(sub.Name+" "+argsLine(sub)), sub.Usage)
+		}
+	}
+	if len(c.Flags) > 0 {
+		set := flagSet(c, name)
+		fmt.Fprintf(w, "\nFLAGS:\n")
+		for _, f := range c.Flags {
+			fmt.Fprintf(w, "    %s\n", describeFlag(set.Lookup(f.FlagName())))
+		}
 	}
+	_ = w.Flush()
+}
+
+// Describes how the command is used, eg. [flags] <id>.
+func usageLine(c *Command) string {
+	var parts []string
+	if len(c.Flags) > 0 {
+		parts = append(parts, "[flags]")
+	}
+	if len(c.Commands) > 0 {
+		parts = append(parts, "command [arguments...]")
+	} else if args := argsLine(c); args != "" {
+		parts = append(parts, args)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Describes the arguments of the command, eg. <file> [output].
+func argsLine(c *Command) string {
+	var args []string
+	for _, arg := range c.Args {
+		if arg.Optional {
+			args = append(args, "["+arg.Name+"]")
+		} else {
+			args = append(args, "<"+arg.Name+">")
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// Creates the flag set with the flags of the command. Errors are returned by Parse and not printed, the help of the
+// command explains the flags.
+func flagSet(c *Command, name string) *flag.FlagSet {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, f := range c.Flags {
+		f.apply(set)
+	}
+	return set
 }
 
 // Executes the cli app, parses the arguments to the relevant command.
 func (a *App) Run(arguments []string) (err error) {
-	if len(arguments) == 0 {
-		a.Help()
-		return nil
-	}
-	cmd := arguments[0]
-	if cmd == "-h" || cmd == "--help" {
-		a.Help()
+	if len(arguments) > 0 && arguments[0] == CompleteCommand {
+		a.complete(arguments[1:])
 		return nil
 	}
+	return a.run(a.root(), a.Name, nil, arguments)
+}
 
-	cmdArgs := []string{}
-	if len(arguments) > 1 {
-		cmdArgs = arguments[1:]
+// Parses the flags of the command with the given full name and executes it, or the subcommand that is given.
+func (a *App) run(c *Command, name string, parent *Context, args []string) error {
+	ctx := &Context{App: a, Command: c, Name: name, flags: flagSet(c, name), set: map[string]bool{}, parent: parent}
+	if len(c.Commands) > 0 {
+		// Flags of this command come before the subcommand, the flag package stops parsing at the first argument
+		if err := ctx.flags.Parse(args); err != nil {
+			return a.flagError(c, name, err)
+		}
+		args = ctx.flags.Args()
+		if len(args) > 0 {
+			for _, sub := range c.Commands {
+				if sub.Name == args[0] {
+					ctx.markSet()
+					return a.run(sub, name+" "+sub.Name, ctx, args[1:])
+				}
+			}
+		}
+		if c.Handler == nil { // If no command was executed and this command does not run itself, display the help menu.
+			a.help(c, name)
+			return nil
+		}
 	}
 
-	isCommand, err := a.executeIfCommand(cmd, cmdArgs)
-	if isCommand || err != nil { // return if the command executed or the error is not nil
+	positional, err := parseInterspersed(ctx.flags, args)
+	if err != nil {
+		return a.flagError(c, name, err)
+	}
+	ctx.Args = positional
+	ctx.markSet()
+	if c.Handler == nil {
+		return fmt.Errorf("%v does not have a handler.", c.Name)
+	}
+	if err := checkArgs(c, name, positional); err != nil {
 		return err
 	}
-	// If no command was executed, display the help menu.
-	a.Help()
-	return nil
+	return c.Handler(ctx)
 }
 
-func (a *App) executeIfCommand(cmd string, args []string) (isCommand bool, err error) {
-	for _, c := range a.Commands {
-		if c.Name == cmd {
-			return true, a.executeCommand(c, args)
+// Parses the flags in args, which may be interleaved with other arguments, and returns the other arguments. Everything
+// after -- is an argument.
+func parseInterspersed(set *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := set.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := set.Args()
+		if len(rest) == 0 {
+			return positional, nil
 		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional, args = append(positional, rest[0]), rest[1:]
 	}
-	return false, nil
 }
 
-// Executes a command's Handler
-func (a *App) executeCommand(c *Command, args []string) error {
-	if c.Handler == nil {
-		return fmt.Errorf("%v does not have a handler.", c.Name)
+// Shows the help if it was asked for, otherwise returns the error with a hint to the help.
+func (a *App) flagError(c *Command, name string, err error) error {
+	if err == flag.ErrHelp {
+		a.help(c, name)
+		return nil
 	}
-	return c.Handler(a, args)
+	return fmt.Errorf("%s: %v, see %s --help", name, err, name)
 }
 
-// Waits for input (until the user types and presses enter) and returns it (including the newline)
-func (a *App) GetInput() string {
-	var in string
-	_, err := fmt.Fscanln(a.Reader, &in)
-	if err != nil {
-		log.Fatal(err)
+// Checks that all required arguments and no more than the defined arguments are given.
+func checkArgs(c *Command, name string, args []string) error {
+	for i, arg := range c.Args {
+		if i >= len(args) && !arg.Optional {
+			return fmt.Errorf("%s: missing argument <%s>, usage: %s %s", name, arg.Name, name, usageLine(c))
+		}
 	}
-	return in
+	if len(args) > len(c.Args) {
+		return fmt.Errorf("%s: unexpected argument %q, usage: %s %s", name, args[len(c.Args)], name, usageLine(c))
+	}
+	return nil
+}
+
+// Waits until the user types a line and presses enter and returns it without the line ending. Returns io.EOF if there
+// is no more input, or the error of the context if it is done first. A line that is not read because the context is
+// done is returned by the next call.
+func (a *App) GetInput(ctx context.Context) (string, error) {
+	a.inputOnce.Do(func() {
+		a.input = newLineReader(a.Reader)
+	})
+	return a.input.read(ctx)
+}
+
+// Waits until the user presses a key and returns it, the terminal should be in raw mode (see MakeRaw). Returns io.EOF
+// if there is no more input, or the error of the context if it is done first. An app reads either lines or keys.
+func (a *App) GetKey(ctx context.Context) (Key, error) {
+	a.keysOnce.Do(func() {
+		a.keys = newKeyReader(a.Reader)
+	})
+	return a.keys.read(ctx)
 }
 
 // Creates a new instance of App with some default values for the name and writer.
@@ -25,7 +25,10 @@
 package cli
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -40,16 +43,16 @@ func TestCommandRouting(t *testing.T) {
 
 	//Setup some command handlers to test
 	app.Commands = []*Command{
-		{Name: "mycommand", Usage: "usage", Handler: func(a *App, args []string) error {
+		{Name: "mycommand", Usage: "usage", Handler: func(c *Context) error {
 			firstCalled += 1
-			if len(args) == 0 {
+			if len(c.Args) == 0 {
 				firstArgsMatchExpected = true
 			}
 			return nil
 		}},
-		{Name: "mycommand2", Usage: "usage", Handler: func(a *App, args []string) error {
+		{Name: "mycommand2", Usage: "usage", Args: []Arg{{Name: "first"}, {Name: "second"}}, Handler: func(c *Context) error {
 			secondCalled += 1
-			if len(args) == 2 && args[0] == "Hello" && args[1] == "Second argument" {
+			if len(c.Args) == 2 && c.Arg("first") == "Hello" && c.Arg("second") == "Second argument" {
 				secondArgsMatchExpected = true
 			}
 			return nil
@@ -76,3 +79,120 @@ func TestCommandRouting(t *testing.T) {
 		t.Error("Second handler call was not propagated properly")
 	}
 }
+
+// Creates an app with nested commands that records the full name of the command that ran.
+func newNestedApp(out *bytes.Buffer) (*App, *string) {
+	ran := ""
+	record := func(c *Context) error {
+		ran = c.Name + " " + strings.Join(c.Args, " ")
+		return nil
+	}
+	app := NewApp()
+	app.Name = "app"
+	app.Writer = out
+	app.Commands = []*Command{{
+		Name:  "policy",
+		Usage: "Manage policies",
+		Flags: []Flag{&StringFlag{Name: "storage", Usage: "the `dir` of the policies"}},
+		Commands: []*Command{
+			{Name: "list", Usage: "List the policies", Handler: record},
+			{Name: "show", Usage: "Show a policy", Args: []Arg{{Name: "id"}}, Handler: record},
+			{Name: "export", Usage: "Export the policies", Args: []Arg{{Name: "file", Optional: true}}, Handler: record},
+			{Name: "broken", Usage: "Fails", Handler: func(c *Context) error { return errors.New("broken") }},
+		},
+	}}
+	return app, &ran
+}
+
+// Makes sure that nested commands are found and their arguments are passed.
+func TestNestedCommands(t *testing.T) {
+	var out bytes.Buffer
+	app, ran := newNestedApp(&out)
+	runs := map[string][]string{
+		"app policy list ":       {"policy", "list"},
+		"app policy show abc":    {"policy", "-storage", "dir", "show", "abc"},
+		"app policy export ":     {"policy", "export"},
+		"app policy export file": {"policy", "export", "file"},
+	}
+	for expected, args := range runs {
+		*ran = ""
+		if err := app.Run(args); err != nil || *ran != expected {
+			t.Errorf("running %v ran %q (%v), expected %q", args, *ran, err, expected)
+		}
+	}
+	if err := app.Run([]string{"policy", "broken"}); err == nil || err.Error() != "broken" {
+		t.Error("the error of the handler was not returned: ", err)
+	}
+	*ran = ""
+	out.Reset()
+	if err := app.Run([]string{"policy", "unknown"}); err != nil || *ran != "" || !strings.Contains(out.String(), "COMMANDS:") {
+		t.Error("an unknown command should show the help: ", err, out.String())
+	}
+}
+
+// Makes sure that a command with subcommands runs its own handler when no subcommand is given.
+func TestCommandWithHandlerAndSubcommands(t *testing.T) {
+	var out bytes.Buffer
+	app, ran := newNestedApp(&out)
+	policy := app.Commands[0]
+	policy.Args = []Arg{{Name: "id", Optional: true}}
+	policy.Handler = func(c *Context) error {
+		*ran = c.Name + " " + strings.Join(c.Args, " ")
+		return nil
+	}
+	runs := map[string][]string{
+		"app policy ":      {"policy"},
+		"app policy abc":   {"policy", "abc"},
+		"app policy list ": {"policy", "list"},
+	}
+	for expected, args := range runs {
+		*ran = ""
+		out.Reset()
+		if err := app.Run(args); err != nil || *ran != expected || out.Len() > 0 {
+			t.Errorf("running %v ran %q (%v, %q), expected %q", args, *ran, err, out.String(), expected)
+		}
+	}
+}
+
+// Makes sure that missing and unexpected arguments are reported before the handler runs.
+func TestRequiredArgs(t *testing.T) {
+	var out bytes.Buffer
+	app, ran := newNestedApp(&out)
+	err := app.Run([]string{"policy", "show"})
+	if err == nil || err.Error() != "app policy show: missing argument <id>, usage: app policy show <id>" {
+		t.Error("unexpected error for a missing argument: ", err)
+	}
+	err = app.Run([]string{"policy", "export", "a", "b"})
+	if err == nil || err.Error() != "app policy export: unexpected argument \"b\", usage: app policy export [file]" {
+		t.Error("unexpected error for too many arguments: ", err)
+	}
+	if err := app.Run([]string{"policy", "list", "x"}); err == nil {
+		t.Error("expected an error for an argument of a command without arguments")
+	}
+	if *ran != "" {
+		t.Error("the handler ran with invalid arguments: ", *ran)
+	}
+}
+
+// Makes sure that every command has a help that describes its commands, arguments and flags.
+func TestCommandHelp(t *testing.T) {
+	var out bytes.Buffer
+	app, ran := newNestedApp(&out)
+	for _, args := range [][]string{{"policy", "--help"}, {"policy"}, {"policy", "-h"}} {
+		out.Reset()
+		if err := app.Run(args); err != nil {
+			t.Fatal(err)
+		}
+		help := out.String()
+		if !strings.Contains(help, "app policy - Manage policies") || !strings.Contains(help, "show <id>") || !strings.Contains(help, "-storage dir  the dir of the policies") {
+			t.Errorf("unexpected help for %v: %s", args, help)
+		}
+	}
+	out.Reset()
+	if err := app.Run([]string{"policy", "export", "--help"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "app policy export [file]") || *ran != "" {
+		t.Error("unexpected help for a command: ", out.String())
+	}
+}
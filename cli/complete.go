@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The hidden command that the completion scripts run to get the candidates for the word under the cursor. Its arguments
+// are the words of the command line after the program name, the last one is the (possibly empty) word under the cursor.
+const CompleteCommand = "__complete"
+
+// Characters that can not be used in the names of shell functions.
+var invalidFunctionChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Completion scripts by shell, %[1]s is the name of the program and %[2]s a name for its completion function. Every
+// script runs the program with CompleteCommand and completes file names if there are no candidates.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for %[1]s, load it with: source <(%[1]s completion bash)
+_%[2]s_complete() {
+    local IFS=$'\n'
+    COMPREPLY=($(%[1]s ` + CompleteCommand + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -o default -F _%[2]s_complete %[1]s
+`,
+	"zsh": `#compdef %[1]s
+# zsh completion for %[1]s, load it with: source <(%[1]s completion zsh)
+_%[2]s_complete() {
+    local -a candidates described
+    local candidate
+    candidates=("${(@f)$(%[1]s ` + CompleteCommand + ` "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    for candidate in ${candidates:#}; do
+        if [[ $candidate == *$'\t'* ]]; then
+            described+=("${${candidate%%%%$'\t'*}//:/\\:}:${candidate#*$'\t'}")
+        else
+            described+=("${candidate//:/\\:}")
+        fi
+    done
+    if (( ${#described} == 0 )); then
+        _files
+        return
+    fi
+    _describe '%[1]s' described
+}
+compdef _%[2]s_complete %[1]s
+`,
+	"fish": `# fish completion for %[1]s, load it with: %[1]s completion fish | source
+function __%[2]s_complete
+    set -l words (commandline -opc)
+    set -l current (commandline -ct)
+    set -l candidates (%[1]s ` + CompleteCommand + ` $words[2..-1] "$current" 2>/dev/null)
+    if test (count $candidates) -eq 0
+        __fish_complete_path "$current"
+        return
+    end
+    printf '%%s\n' $candidates
+end
+complete -c %[1]s -f -a '(__%[2]s_complete)'
+`,
+}
+
+// Creates the command that prints the completion script for a shell: completion bash|zsh|fish. The scripts complete
+// the commands, flags and arguments of the app, see CompleteCommand and Command.Complete.
+func NewCompletionCommand() *Command {
+	return &Command{
+		Name:  "completion",
+		Usage: "Print the shell completion script for bash, zsh or fish, eg. source <(backstage-hook completion bash)",
+		Args:  []Arg{{Name: "shell"}},
+		Complete: func(c *Context) []string {
+			return []string{"bash", "fish", "zsh"}
+		},
+		Handler: func(c *Context) error {
+			script, ok := completionScripts[c.Arg("shell")]
+			if !ok {
+				return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", c.Arg("shell"))
+			}
+			fmt.Fprintf(c.App.Writer, script, c.App.Name, invalidFunctionChars.ReplaceAllString(c.App.Name, "_"))
+			return nil
+		},
+	}
+}
+
+// Prints the candidates for the last word to the writer of the app, one per line. A candidate may be followed by a tab
+// and its description. Nothing is printed if the shell should complete file names.
+func (a *App) complete(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	words, current := words[:len(words)-1], words[len(words)-1]
+	c, name := a.root(), a.Name
+	ctx := &Context{App: a, Command: c, Name: name, flags: flagSet(c, name), set: map[string]bool{}}
+	var args []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case word == "--":
+			args = append(args, words[i+1:]...)
+			i = len(words)
+		case len(word) > 1 && strings.HasPrefix(word, "-"):
+			flagName, value, hasValue := splitFlag(word)
+			if !hasValue && takesValue(ctx.flags.Lookup(flagName)) {
+				if i+1 == len(words) {
+					return // The word under the cursor is the value of the flag
+				}
+				i++
+				value = words[i]
+			}
+			if ctx.flags.Set(flagName, value) == nil {
+				ctx.set[flagName] = true
+			}
+		default:
+			if sub := subcommand(c, word); sub != nil && len(args) == 0 {
+				c, name = sub, name+" "+sub.Name
+				ctx = &Context{App: a, Command: c, Name: name, flags: flagSet(c, name), set: map[string]bool{}, parent: ctx}
+				continue
+			}
+			args = append(args, word)
+		}
+	}
+	ctx.Args = args
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(current, "-"):
+		candidates = flagCandidates(ctx)
+	case len(c.Commands) > 0 && len(args) == 0:
+		for _, sub := range c.Commands {
+			candidates = append(candidates, sub.Name+"\t"+sub.Usage)
+		}
+	case c.Complete != nil:
+		candidates = c.Complete(ctx)
+	case len(args) >= len(c.Args):
+		candidates = flagCandidates(ctx) // The command takes no more arguments
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Fprintln(a.Writer, candidate)
+		}
+	}
+}
+
+// Splits a flag like --name=value into its name and value.
+func splitFlag(word string) (name string, value string, hasValue bool) {
+	name = strings.TrimPrefix(strings.TrimPrefix(word, "-"), "-")
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		return name[:i], name[i+1:], true
+	}
+	return name, "", false
+}
+
+// Whether the flag takes a value, it does not if it is a boolean flag or if there is no such flag.
+func takesValue(f *flag.Flag) bool {
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// Returns the subcommand of c with the given name, or nil if there is none.
+func subcommand(c *Command, name string) *Command {
+	for _, sub := range c.Commands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Returns the flags of the command of ctx with their descriptions.
+func flagCandidates(ctx *Context) []string {
+	var candidates []string
+	for _, f := range ctx.Command.Flags {
+		_, usage := flag.UnquoteUsage(ctx.flags.Lookup(f.FlagName()))
+		candidates = append(candidates, "-"+f.FlagName()+"\t"+usage)
+	}
+	return candidates
+}
@@ -0,0 +1,162 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates an app with nested commands, flags and dynamic completions.
+func newCompletionApp(out io.Writer) *App {
+	app := NewApp()
+	app.Name = "app"
+	app.Writer = out
+	app.Commands = []*Command{
+		{
+			Name:  "start",
+			Usage: "Start it",
+			Flags: []Flag{&StringFlag{Name: "listen", Usage: "the `address` to listen on"}, &BoolFlag{Name: "verbose", Usage: "print more"}},
+			Args:  []Arg{{Name: "url"}},
+		},
+		{
+			Name:  "policy",
+			Usage: "Manage policies",
+			Commands: []*Command{
+				{
+					Name:  "show",
+					Usage: "Show a policy",
+					Flags: []Flag{&StringFlag{Name: "storage"}},
+					Args:  []Arg{{Name: "id"}},
+					Complete: func(c *Context) []string {
+						return []string{"abc\tin " + c.String("storage"), "abd", "xyz"}
+					},
+				},
+			},
+		},
+		NewCompletionCommand(),
+	}
+	return app
+}
+
+// Runs the hidden completion command with the words and returns the candidates.
+func complete(t *testing.T, words ...string) []string {
+	var out bytes.Buffer
+	if err := newCompletionApp(&out).Run(append([]string{CompleteCommand}, words...)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+// Makes sure that commands, flags and arguments are completed from the command tree.
+func TestComplete(t *testing.T) {
+	cases := []struct {
+		words    []string
+		expected []string
+	}{
+		{nil, []string{"start\tStart it", "policy\tManage policies", "completion\t" + NewCompletionCommand().Usage}},
+		{[]string{"p"}, []string{"policy\tManage policies"}},
+		{[]string{"policy", ""}, []string{"show\tShow a policy"}},
+		{[]string{"start", "-"}, []string{"-listen\tthe address to listen on", "-verbose\tprint more"}},
+		{[]string{"start", "-listen", ""}, nil},  // The value of a flag, files are completed
+		{[]string{"start", "-verbose", ""}, nil}, // The url, files are completed
+		{[]string{"start", "-listen", "addr", "url", ""}, []string{"-listen\tthe address to listen on", "-verbose\tprint more"}},
+		{[]string{"policy", "show", "ab"}, []string{"abc\tin ", "abd"}},
+		{[]string{"policy", "show", "-storage", "dir", "a"}, []string{"abc\tin dir", "abd"}},
+		{[]string{"policy", "show", "--storage=dir", "a"}, []string{"abc\tin dir", "abd"}},
+		{[]string{"completion", "z"}, []string{"zsh"}},
+	}
+	for _, c := range cases {
+		candidates := complete(t, c.words...)
+		if strings.Join(candidates, "|") != strings.Join(c.expected, "|") {
+			t.Errorf("completing %q: expected %q, got %q", c.words, c.expected, candidates)
+		}
+	}
+}
+
+// Makes sure that the completion scripts are generated for every shell, and that the bash script works.
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var out bytes.Buffer
+		if err := newCompletionApp(&out).Run([]string{"completion", shell}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "app "+CompleteCommand) {
+			t.Errorf("the %s script does not complete with the app: %s", shell, out.String())
+		}
+	}
+	if err := newCompletionApp(ioutil.Discard).Run([]string{"completion", "powershell"}); err == nil {
+		t.Error("expected an error for an unknown shell")
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	var script bytes.Buffer
+	_ = newCompletionApp(&script).Run([]string{"completion", "bash"})
+	dir, err := ioutil.TempDir("", "backstage-hook-completion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The script runs app, which is this test binary answering with the candidates of the completion app
+	app := "#!/bin/sh\nexec " + os.Args[0] + " -test.run=TestCompletionHelper -- \"$@\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "app"), []byte(app), 0700); err != nil {
+		t.Fatal(err)
+	}
+	test := script.String() + "COMP_WORDS=(app policy show a); COMP_CWORD=3; _app_complete; echo \"${COMPREPLY[*]}\""
+	cmd := exec.Command(bash, "-c", test)
+	cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"), "BACKSTAGE_HOOK_COMPLETION_HELPER=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil || string(output) != "abc abd\n" {
+		t.Errorf("unexpected bash completion %q: %v", output, err)
+	}
+}
+
+// Not a test: runs the completion app with the arguments after -- when started by TestCompletionScripts.
+func TestCompletionHelper(t *testing.T) {
+	if os.Getenv("BACKSTAGE_HOOK_COMPLETION_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	_ = newCompletionApp(os.Stdout).Run(args)
+	os.Exit(0)
+}
@@ -0,0 +1,114 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"flag"
+	"time"
+)
+
+// The context in which a command is executed: the parsed flags and arguments. The flags of the commands it is nested
+// in and of the app are available as well.
+type Context struct {
+	// The app that runs the command
+	App *App
+	// The command that is executed
+	Command *Command
+	// The full name of the command, eg. backstage-hook policy show
+	Name string
+	// The arguments that are not flags, in order
+	Args []string
+
+	flags  *flag.FlagSet
+	set    map[string]bool
+	parent *Context
+}
+
+// Returns the value of the argument with the given name (see Command.Args), or an empty string if it was not given.
+func (c *Context) Arg(name string) string {
+	for i, arg := range c.Command.Args {
+		if arg.Name == name && i < len(c.Args) {
+			return c.Args[i]
+		}
+	}
+	return ""
+}
+
+// Whether the flag was given on the command line.
+func (c *Context) IsSet(name string) bool {
+	for ctx := c; ctx != nil; ctx = ctx.parent {
+		if ctx.flags.Lookup(name) != nil {
+			return ctx.set[name]
+		}
+	}
+	return false
+}
+
+// Returns the value of a StringFlag, an empty string if there is no such flag.
+func (c *Context) String(name string) string {
+	s, _ := c.value(name).(string)
+	return s
+}
+
+// Returns the value of an IntFlag, zero if there is no such flag.
+func (c *Context) Int(name string) int {
+	i, _ := c.value(name).(int)
+	return i
+}
+
+// Returns the value of a BoolFlag, false if there is no such flag.
+func (c *Context) Bool(name string) bool {
+	b, _ := c.value(name).(bool)
+	return b
+}
+
+// Returns the value of a DurationFlag, zero if there is no such flag.
+func (c *Context) Duration(name string) time.Duration {
+	d, _ := c.value(name).(time.Duration)
+	return d
+}
+
+// Returns every value of a StringsFlag in the order they were given, nil if there is no such flag.
+func (c *Context) Strings(name string) []string {
+	s, _ := c.value(name).([]string)
+	return s
+}
+
+// Returns the value of the flag of this command, or of the closest command it is nested in that has the flag.
+func (c *Context) value(name string) interface{} {
+	for ctx := c; ctx != nil; ctx = ctx.parent {
+		if f := ctx.flags.Lookup(name); f != nil {
+			return f.Value.(flag.Getter).Get()
+		}
+	}
+	return nil
+}
+
+// Records which flags were given on the command line, after they were parsed.
+func (c *Context) markSet() {
+	c.flags.Visit(func(f *flag.Flag) {
+		c.set[f.Name] = true
+	})
+}
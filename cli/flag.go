@@ -0,0 +1,126 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A flag of a command or app, eg. -listen address. Flags are parsed with the flag package of the standard library, so
+// they can be given with one or two dashes and their values as -name value or -name=value.
+type Flag interface {
+	// The name of the flag, without dashes
+	FlagName() string
+	// The description of the flag, a name in back quotes is shown as the name of its value (eg. "the `dir` to use")
+	FlagUsage() string
+	// Defines the flag in set
+	apply(set *flag.FlagSet)
+}
+
+// A flag with a string value.
+type StringFlag struct {
+	Name  string
+	Usage string
+	// The value if the flag is not given
+	Value string
+}
+
+// A flag with an integer value.
+type IntFlag struct {
+	Name  string
+	Usage string
+	// The value if the flag is not given
+	Value int
+}
+
+// A flag that is either given (true) or not. It does not take a value, but -name=false is accepted.
+type BoolFlag struct {
+	Name  string
+	Usage string
+}
+
+// A flag with a duration value, eg. 30s or 1h30m.
+type DurationFlag struct {
+	Name  string
+	Usage string
+	// The value if the flag is not given
+	Value time.Duration
+}
+
+// A flag that can be given multiple times, every value is kept in order.
+type StringsFlag struct {
+	Name  string
+	Usage string
+}
+
+func (f *StringFlag) FlagName() string   { return f.Name }
+func (f *IntFlag) FlagName() string      { return f.Name }
+func (f *BoolFlag) FlagName() string     { return f.Name }
+func (f *DurationFlag) FlagName() string { return f.Name }
+func (f *StringsFlag) FlagName() string  { return f.Name }
+
+func (f *StringFlag) FlagUsage() string   { return f.Usage }
+func (f *IntFlag) FlagUsage() string      { return f.Usage }
+func (f *BoolFlag) FlagUsage() string     { return f.Usage }
+func (f *DurationFlag) FlagUsage() string { return f.Usage }
+func (f *StringsFlag) FlagUsage() string  { return f.Usage }
+
+func (f *StringFlag) apply(set *flag.FlagSet)   { set.String(f.Name, f.Value, f.Usage) }
+func (f *IntFlag) apply(set *flag.FlagSet)      { set.Int(f.Name, f.Value, f.Usage) }
+func (f *BoolFlag) apply(set *flag.FlagSet)     { set.Bool(f.Name, false, f.Usage) }
+func (f *DurationFlag) apply(set *flag.FlagSet) { set.Duration(f.Name, f.Value, f.Usage) }
+func (f *StringsFlag) apply(set *flag.FlagSet)  { set.Var(&stringsValue{}, f.Name, f.Usage) }
+
+// The value of a StringsFlag.
+type stringsValue []string
+
+func (s *stringsValue) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsValue) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func (s *stringsValue) Get() interface{} {
+	return []string(*s)
+}
+
+// Describes a flag of a command for its help, eg. "-listen address  the address to listen on (default 127.0.0.1:7077)".
+func describeFlag(f *flag.Flag) string {
+	name, usage := flag.UnquoteUsage(f)
+	s := "-" + f.Name
+	if name != "" {
+		s += " " + name
+	}
+	if f.DefValue != "" && f.DefValue != "0" && f.DefValue != "false" && f.DefValue != "0s" {
+		usage += fmt.Sprintf(" (default %s)", f.DefValue)
+	}
+	return s + "\t" + usage
+}
@@ -0,0 +1,128 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates an app with a single command that records its context, output is written to out.
+func newFlagApp(out *bytes.Buffer, flags ...Flag) (*App, **Context) {
+	var got *Context
+	app := NewApp()
+	app.Writer, app.ErrWriter = out, out
+	app.Commands = []*Command{{
+		Name:  "run",
+		Usage: "Run something",
+		Flags: flags,
+		Args:  []Arg{{Name: "target"}, {Name: "extra", Optional: true}},
+		Handler: func(c *Context) error {
+			got = c
+			return nil
+		},
+	}}
+	return app, &got
+}
+
+// Makes sure that every type of flag is parsed, wherever it is given between the arguments.
+func TestFlags(t *testing.T) {
+	var out bytes.Buffer
+	app, got := newFlagApp(&out,
+		&StringFlag{Name: "name", Value: "default"},
+		&IntFlag{Name: "count", Value: 1},
+		&BoolFlag{Name: "verbose"},
+		&DurationFlag{Name: "timeout", Value: time.Minute},
+		&StringsFlag{Name: "tag"},
+	)
+	err := app.Run([]string{"run", "-count", "3", "target", "--verbose", "-timeout=90s", "-tag", "a", "extra", "-tag", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := *got
+	if c.String("name") != "default" || c.Int("count") != 3 || !c.Bool("verbose") || c.Duration("timeout") != 90*time.Second {
+		t.Error("unexpected flag values: ", c.String("name"), c.Int("count"), c.Bool("verbose"), c.Duration("timeout"))
+	}
+	if tags := c.Strings("tag"); len(tags) != 2 || tags[0] != "a" || tags[1] != "b" {
+		t.Error("unexpected repeated flag values: ", tags)
+	}
+	if c.Arg("target") != "target" || c.Arg("extra") != "extra" {
+		t.Error("unexpected arguments: ", c.Args)
+	}
+	if !c.IsSet("count") || c.IsSet("name") || c.IsSet("undefined") {
+		t.Error("flags that were given are not told apart from defaults")
+	}
+	if c.String("undefined") != "" || c.Strings("undefined") != nil {
+		t.Error("undefined flags should have zero values")
+	}
+}
+
+// Makes sure that everything after -- is an argument, even if it looks like a flag.
+func TestFlagTerminator(t *testing.T) {
+	var out bytes.Buffer
+	app, got := newFlagApp(&out, &BoolFlag{Name: "verbose"})
+	if err := app.Run([]string{"run", "--", "-verbose"}); err != nil {
+		t.Fatal(err)
+	}
+	if (*got).Bool("verbose") || (*got).Arg("target") != "-verbose" {
+		t.Error("a flag after -- was parsed: ", (*got).Args)
+	}
+}
+
+// Makes sure that invalid flags are reported with a hint to the help of the command.
+func TestFlagErrors(t *testing.T) {
+	var out bytes.Buffer
+	app, got := newFlagApp(&out, &IntFlag{Name: "count"}, &DurationFlag{Name: "timeout"})
+	for _, args := range [][]string{{"run", "-unknown", "x"}, {"run", "-count", "many", "x"}, {"run", "x", "-timeout"}} {
+		err := app.Run(args)
+		if err == nil || !strings.Contains(err.Error(), "see "+app.Name+" run --help") {
+			t.Errorf("expected an error for %v, got %v", args, err)
+		}
+	}
+	if *got != nil {
+		t.Error("the handler ran with invalid flags")
+	}
+	if out.Len() != 0 {
+		t.Error("flag errors should be returned, not printed: ", out.String())
+	}
+}
+
+// Makes sure that flags of the app are available to the commands.
+func TestAppFlags(t *testing.T) {
+	var out bytes.Buffer
+	app, got := newFlagApp(&out, &StringFlag{Name: "name"})
+	app.Flags = []Flag{&StringFlag{Name: "config"}, &StringFlag{Name: "name", Value: "app"}}
+	if err := app.Run([]string{"-config", "file", "-name", "outer", "run", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if (*got).String("config") != "file" || !(*got).IsSet("config") {
+		t.Error("the flag of the app is not available to the command")
+	}
+	if (*got).String("name") != "" || (*got).IsSet("name") {
+		t.Error("the flag of the command should hide the flag of the app with the same name")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+)
+
+// Reads lines in the background, so waiting for a line can be cancelled.
+type lineReader struct {
+	lines chan string
+	// The error that ended the input, set before lines is closed
+	err error
+}
+
+// Starts reading the lines of r, there is no input if r is nil.
+func newLineReader(r io.Reader) *lineReader {
+	if r == nil {
+		r = strings.NewReader("")
+	}
+	l := &lineReader{lines: make(chan string)}
+	go l.run(bufio.NewReader(r))
+	return l
+}
+
+// Sends every line to lines until the input ends. The last line does not need a line ending.
+func (l *lineReader) run(r *bufio.Reader) {
+	for {
+		text, err := r.ReadString('\n')
+		if text != "" {
+			l.lines <- strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		}
+		if err != nil {
+			l.err = err
+			close(l.lines)
+			return
+		}
+	}
+}
+
+// Returns the next line, the error that ended the input or the error of ctx if it is done first.
+func (l *lineReader) read(ctx context.Context) (string, error) {
+	select {
+	case text, ok := <-l.lines:
+		if !ok {
+			return "", l.err
+		}
+		return text, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Makes sure that whole lines are returned without their line ending and that the end of the input is reported.
+func TestGetInput(t *testing.T) {
+	app := &App{Reader: strings.NewReader("allow always\r\n\nlast")}
+	for _, expected := range []string{"allow always", "", "last"} {
+		in, err := app.GetInput(context.Background())
+		if err != nil || in != expected {
+			t.Errorf("expected %q, got %q (%v)", expected, in, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := app.GetInput(context.Background()); err != io.EOF {
+			t.Error("expected the end of the input, got ", err)
+		}
+	}
+	if _, err := (&App{}).GetInput(context.Background()); err != io.EOF {
+		t.Error("expected no input without a reader, got ", err)
+	}
+}
+
+// Makes sure that waiting for input can be cancelled and that the line is returned by the next call.
+func TestGetInputCancel(t *testing.T) {
+	r, w := io.Pipe()
+	app := &App{Reader: r}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := app.GetInput(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected the deadline to be exceeded, got ", err)
+	}
+	go w.Write([]byte("d\n"))
+	if in, err := app.GetInput(context.Background()); err != nil || in != "d" {
+		t.Errorf("expected d, got %q (%v)", in, err)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+)
+
+// A key that was pressed, either a character or one of the special keys below.
+type Key rune
+
+const (
+	// The enter key
+	KeyEnter Key = '\r'
+	// Ctrl-C, in raw mode it does not interrupt the program
+	KeyInterrupt Key = 3
+	// The escape key
+	KeyEscape Key = 27
+	// The arrow keys, they are sent as escape sequences and do not have a character
+	KeyUp    Key = -1
+	KeyDown  Key = -2
+	KeyRight Key = -3
+	KeyLeft  Key = -4
+)
+
+// The arrow keys by the last character of their escape sequence, eg. ESC [ A for KeyUp.
+var arrowKeys = map[byte]Key{'A': KeyUp, 'B': KeyDown, 'C': KeyRight, 'D': KeyLeft}
+
+// Reads the next key from r. An escape sequence is only recognized if it was received at once, as terminals send it.
+func readKey(r *bufio.Reader) (Key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case '\n':
+		return KeyEnter, nil
+	case rune(KeyEscape):
+		if r.Buffered() < 2 {
+			return KeyEscape, nil
+		}
+		seq, _ := r.Peek(2)
+		if key, ok := arrowKeys[seq[1]]; ok && (seq[0] == '[' || seq[0] == 'O') {
+			_, _ = r.Discard(2)
+			return key, nil
+		}
+		return KeyEscape, nil
+	}
+	return Key(c), nil
+}
+
+// Reads keys in the background, so waiting for a key can be cancelled.
+type keyReader struct {
+	keys chan Key
+	// The error that ended the input, set before keys is closed
+	err error
+}
+
+// Starts reading the keys of r, there is no input if r is nil.
+func newKeyReader(r io.Reader) *keyReader {
+	if r == nil {
+		r = strings.NewReader("")
+	}
+	k := &keyReader{keys: make(chan Key)}
+	go k.run(bufio.NewReader(r))
+	return k
+}
+
+// Sends every key to keys until the input ends.
+func (k *keyReader) run(r *bufio.Reader) {
+	for {
+		key, err := readKey(r)
+		if err != nil {
+			k.err = err
+			close(k.keys)
+			return
+		}
+		k.keys <- key
+	}
+}
+
+// Returns the next key, the error that ended the input or the error of ctx if it is done first.
+func (k *keyReader) read(ctx context.Context) (Key, error) {
+	select {
+	case key, ok := <-k.keys:
+		if !ok {
+			return 0, k.err
+		}
+		return key, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
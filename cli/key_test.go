@@ -0,0 +1,46 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cli
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Makes sure that characters, the arrow keys and enter are recognized.
+func TestGetKey(t *testing.T) {
+	app := &App{Reader: strings.NewReader("a\x1b[A\x1bOB\x1b[C\x1b[D\r\n\x03é\x1b")}
+	expected := []Key{'a', KeyUp, KeyDown, KeyRight, KeyLeft, KeyEnter, KeyEnter, KeyInterrupt, 'é', KeyEscape}
+	for _, e := range expected {
+		if key, err := app.GetKey(context.Background()); err != nil || key != e {
+			t.Errorf("expected key %q, got %q (%v)", e, key, err)
+		}
+	}
+	if _, err := app.GetKey(context.Background()); err != io.EOF {
+		t.Error("expected the end of the input, got ", err)
+	}
+}
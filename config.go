@@ -0,0 +1,82 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/config"
+	"github.com/tcorp-bv/backstage-hook/storage"
+	"os"
+	"text/tabwriter"
+)
+
+// The -config flag of the app, it applies to every command.
+var configFlag = &cli.StringFlag{Name: "config", Usage: "the configuration `file` (default: " + config.FileName + " in the backstage-hook directory of your config directory)"}
+
+// Inspects the configuration: backstage-hook config show
+var configCommand = &cli.Command{
+	Name:     "config",
+	Usage:    "Inspect the configuration from the configuration file, the environment and flags",
+	Commands: []*cli.Command{configShowCommand},
+}
+
+// Prints the effective configuration and where every value came from.
+var configShowCommand = &cli.Command{
+	Name:  "show",
+	Usage: "Show the effective configuration with the source of every value",
+	Flags: config.CliFlags(),
+	Handler: func(c *cli.Context) error {
+		cfg, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "Configuration file: %s\n\n", cfg.File)
+		w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "OPTION\tVALUE\tSOURCE\n")
+		for _, v := range cfg.Values() {
+			value := v.Value
+			if value == "" {
+				value = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, value, v.Source)
+		}
+		return w.Flush()
+	},
+}
+
+// Loads the configuration of the command, with the file of the -config flag and the flags of the command.
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	return config.Load(c.String("config"), os.LookupEnv, c)
+}
+
+// Opens the store in the configured storage directory.
+func loadStore(c *cli.Context) (storage.Store, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(cfg.Storage)
+}
@@ -0,0 +1,380 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package config loads the configuration of the hook. Every option has a default, which is overridden by the
+// configuration file, which is overridden by an environment variable, which is overridden by a flag:
+//
+//	{"listen": "127.0.0.1:8080", "ui": "web", "passEnv": ["KUBECONFIG"]}  in the configuration file
+//	BACKSTAGE_HOOK_LISTEN=127.0.0.1:8080                                 in the environment
+//	-listen 127.0.0.1:8080                                               on the command line
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// The name of the configuration file in the configuration directory of the hook
+	FileName = "config.json"
+	// The prefix of the environment variables of the options, eg. BACKSTAGE_HOOK_LISTEN
+	EnvPrefix = "BACKSTAGE_HOOK_"
+	// The environment variable with the path of the configuration file
+	FileEnv = EnvPrefix + "CONFIG"
+	// The source of the options that are not set anywhere
+	Default = "default"
+)
+
+// The configuration of the hook.
+type Config struct {
+	// The address the hook listens on for actions
+	Listen string
+	// The url of the Backstage instance that may send actions, eg. http://localhost:3000
+	Backstage string
+	// The directory in which policies and sessions are stored, storage.DefaultDir if empty
+	Storage string
+	// Where actions are approved: cli or web
+	UI string
+	// Commands that run longer are killed, unless the action requests another timeout. There is no timeout if zero.
+	Timeout time.Duration
+	// The environment commands start from: minimal or empty
+	Env string
+	// The names of more variables in the environment of the hook that are passed to every command
+	PassEnv []string
+	// The resource limits of every command
+	Limits executor.Limits
+	// The policy file whose rules are loaded on start, none if empty
+	PolicyFile string
+	// The audit log file, audit.log in the storage directory if empty
+	Audit string
+
+	// The file the configuration was loaded from, it may not exist
+	File string
+	// The source of every option by name, see Source
+	sources map[string]string
+}
+
+// The type of the value of an option.
+type kind int
+
+const (
+	text kind = iota
+	duration
+	list
+)
+
+// An option of the configuration.
+type option struct {
+	// The key in the configuration file
+	key string
+	// The name of the flag, the environment variable is EnvPrefix followed by the name in upper case with underscores
+	flag string
+	// The value if the option is not set anywhere
+	value string
+	// The type of the value, lists are comma separated in the environment and may be repeated as flags
+	kind kind
+	// Describes the option in the help of its flag
+	usage string
+}
+
+// The options of the configuration, in the order they are shown.
+var options = []option{
+	{"listen", "listen", "127.0.0.1:7077", text, "the `address` to listen on for actions"},
+	{"backstage", "backstage", "", text, "the `url` of your Backstage instance"},
+	{"storage", "storage", "", text, "the `dir`ectory in which policies and sessions are stored (default: backstage-hook in your config directory)"},
+	{"ui", "ui", "cli", text, "where actions are approved: cli (this terminal) or web (the browser)"},
+	{"timeout", "timeout", "30m", duration, "kill commands that run longer, unless the plugin requests another timeout (0 disables the timeout)"},
+	{"env", "env", "minimal", text, "the environment commands start from: minimal (HOME, PATH and the like from your environment) or empty"},
+	{"passEnv", "pass-env", "", list, "comma separated `names` of more variables in your environment that are passed to every command, may be repeated"},
+	{"limits", "limits", "", text, "resource `limits` of every command, eg. cpu=60,memory=2G,openFiles=1024,fileSize=10G,processes=512 (cpu in seconds)"},
+	{"policyFile", "policy-file", "", text, "load the rules in this policy `file`, replacing the rules of the file that was loaded before"},
+	{"audit", "audit", "", text, "the audit log `file` (default: audit.log in the storage directory)"},
+}
+
+// Sets the option with the given key in c, the value is in the format of the environment variables.
+func (c *Config) set(key string, value string) error {
+	switch key {
+	case "listen":
+		c.Listen = value
+	case "backstage":
+		c.Backstage = value
+	case "storage":
+		c.Storage = value
+	case "ui":
+		if value != "cli" && value != "web" {
+			return fmt.Errorf("unknown ui %q, expected cli or web", value)
+		}
+		c.UI = value
+	case "timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout %q, expected eg. 30m", value)
+		}
+		c.Timeout = d
+	case "env":
+		if value != "minimal" && value != "empty" {
+			return fmt.Errorf("unknown environment %q, expected minimal or empty", value)
+		}
+		c.Env = value
+	case "passEnv":
+		c.PassEnv = nil
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !actions.ValidEnvName(name) {
+				return fmt.Errorf("invalid environment variable name %q", name)
+			}
+			c.PassEnv = append(c.PassEnv, name)
+		}
+	case "limits":
+		l, err := executor.ParseLimits(value)
+		if err != nil {
+			return err
+		}
+		c.Limits = l
+	case "policyFile":
+		c.PolicyFile = value
+	case "audit":
+		c.Audit = value
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
+
+// Returns the value of the option with the given key in c, in the format of the environment variables.
+func (c *Config) get(key string) string {
+	switch key {
+	case "listen":
+		return c.Listen
+	case "backstage":
+		return c.Backstage
+	case "storage":
+		return c.Storage
+	case "ui":
+		return c.UI
+	case "timeout":
+		return c.Timeout.String()
+	case "env":
+		return c.Env
+	case "passEnv":
+		return strings.Join(c.PassEnv, ",")
+	case "limits":
+		return c.Limits.String()
+	case "policyFile":
+		return c.PolicyFile
+	case "audit":
+		return c.Audit
+	}
+	return ""
+}
+
+// The flags that were given on the command line, this is implemented by cli.Context.
+type Flags interface {
+	// Whether the flag was given
+	IsSet(name string) bool
+	String(name string) string
+	Strings(name string) []string
+	Duration(name string) time.Duration
+}
+
+// Returns the flags of the options with the given keys, eg. "storage", for a command that uses them. All options
+// have a flag if no keys are given. The defaults of the flags are the defaults of the options, a flag only overrides
+// the file and the environment when it is given.
+func CliFlags(keys ...string) []cli.Flag {
+	var flags []cli.Flag
+	for _, o := range options {
+		if len(keys) > 0 && !contains(keys, o.key) {
+			continue
+		}
+		switch o.kind {
+		case duration:
+			d, _ := time.ParseDuration(o.value)
+			flags = append(flags, &cli.DurationFlag{Name: o.flag, Value: d, Usage: o.usage})
+		case list:
+			flags = append(flags, &cli.StringsFlag{Name: o.flag, Usage: o.usage})
+		default:
+			flags = append(flags, &cli.StringFlag{Name: o.flag, Value: o.value, Usage: o.usage})
+		}
+	}
+	return flags
+}
+
+// Whether s contains v.
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the path of the configuration file in the configuration directory of the user, eg.
+// ~/.config/backstage-hook/config.json on Linux ($XDG_CONFIG_HOME is used if set).
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backstage-hook", FileName), nil
+}
+
+// Loads the configuration: the defaults, overlaid by the configuration file, the environment variables that lookupEnv
+// (eg. os.LookupEnv) finds and the flags that were given. The file is at path, the path in the FileEnv variable or
+// DefaultPath, in that order. Only a file at the default path may be missing. Flags may be nil.
+func Load(path string, lookupEnv func(string) (string, bool), flags Flags) (*Config, error) {
+	c := &Config{sources: map[string]string{}}
+	for _, o := range options {
+		if err := c.set(o.key, o.value); err != nil {
+			panic(err) // The defaults are valid
+		}
+		c.sources[o.key] = Default
+	}
+
+	optional := false
+	if path == "" {
+		path, _ = lookupEnv(FileEnv)
+	}
+	if path == "" {
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			return nil, err
+		}
+		optional = true
+	}
+	c.File = path
+	if err := c.loadFile(path, optional); err != nil {
+		return nil, err
+	}
+
+	for _, o := range options {
+		name := EnvPrefix + strings.ToUpper(strings.Replace(o.flag, "-", "_", -1))
+		if value, ok := lookupEnv(name); ok {
+			if err := c.Set(o.key, value, "env "+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if flags == nil {
+		return c, nil
+	}
+	for _, o := range options {
+		if !flags.IsSet(o.flag) {
+			continue
+		}
+		var value string
+		switch o.kind {
+		case duration:
+			value = flags.Duration(o.flag).String()
+		case list:
+			value = strings.Join(flags.Strings(o.flag), ",")
+		default:
+			value = flags.String(o.flag)
+		}
+		if err := c.Set(o.key, value, "flag -"+o.flag); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Overlays the options in the configuration file at path. Values are strings, lists may also be arrays of strings.
+func (c *Config) loadFile(path string, optional bool) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && optional {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var value string
+		if err := json.Unmarshal(values[key], &value); err != nil {
+			var list []string
+			if err := json.Unmarshal(values[key], &list); err != nil || !bytes.HasPrefix(values[key], []byte("[")) {
+				return fmt.Errorf("%s: %s must be a string", path, key)
+			}
+			value = strings.Join(list, ",")
+		}
+		if err := c.Set(key, value, "file "+path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the option with the given key (eg. listen) to value, source tells where the value came from (eg. flag -listen).
+func (c *Config) Set(key string, value string, source string) error {
+	if err := c.set(key, value); err != nil {
+		return fmt.Errorf("%s (%s): %v", key, source, err)
+	}
+	c.sources[key] = source
+	return nil
+}
+
+// A configured option as shown to the user.
+type Value struct {
+	// The key of the option in the configuration file
+	Key string
+	// The value as it would be written in the configuration file, lists are comma separated
+	Value string
+	// Where the value came from: Default, file <path>, env <name> or flag -<name>
+	Source string
+}
+
+// Returns every option with its value and source, in a fixed order.
+func (c *Config) Values() []Value {
+	values := make([]Value, len(options))
+	for i, o := range options {
+		values[i] = Value{Key: o.key, Value: c.get(o.key), Source: c.Source(o.key)}
+	}
+	return values
+}
+
+// Returns where the value of the option with the given key came from, see Value.
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
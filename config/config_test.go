@@ -0,0 +1,160 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package config
+
+import (
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a configuration file in a new temporary directory, the directory must be removed by the caller.
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, FileName)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Returns a lookupEnv function for Load that finds the variables in env.
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+// Loads the configuration with the flags parsed from args by a command that has all flags of the configuration.
+func loadWithArgs(t *testing.T, path string, env map[string]string, args ...string) (*Config, error) {
+	var c *Config
+	var err error
+	app := cli.NewApp()
+	app.Commands = []*cli.Command{{
+		Name:  "test",
+		Flags: CliFlags(),
+		Handler: func(ctx *cli.Context) error {
+			c, err = Load(path, lookupIn(env), ctx)
+			return nil
+		},
+	}}
+	if runErr := app.Run(append([]string{"test"}, args...)); runErr != nil {
+		t.Fatal(runErr)
+	}
+	return c, err
+}
+
+// Makes sure that the file overrides the defaults, the environment overrides the file and flags override the
+// environment, and that the source of every value is reported.
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `{"listen": "127.0.0.1:1", "ui": "web", "timeout": "1m", "passEnv": ["KUBECONFIG", "AWS_PROFILE"]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	env := map[string]string{EnvPrefix + "LISTEN": "127.0.0.1:2", EnvPrefix + "TIMEOUT": "2m", EnvPrefix + "POLICY_FILE": "rules.json"}
+
+	c, err := loadWithArgs(t, path, env, "-timeout", "3m", "-limits", "cpu=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.File != path {
+		t.Error("unexpected file: ", c.File)
+	}
+	if c.Listen != "127.0.0.1:2" || c.UI != "web" || c.Timeout != 3*time.Minute || c.PolicyFile != "rules.json" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if strings.Join(c.PassEnv, ",") != "KUBECONFIG,AWS_PROFILE" || c.Limits != (executor.Limits{CPU: 10}) || c.Env != "minimal" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	expected := map[string]string{
+		"listen":     "env " + EnvPrefix + "LISTEN",
+		"ui":         "file " + path,
+		"timeout":    "flag -timeout",
+		"passEnv":    "file " + path,
+		"limits":     "flag -limits",
+		"policyFile": "env " + EnvPrefix + "POLICY_FILE",
+		"env":        Default,
+	}
+	for key, source := range expected {
+		if c.Source(key) != source {
+			t.Errorf("expected %s to come from %q, got %q", key, source, c.Source(key))
+		}
+	}
+	values := c.Values()
+	if len(values) != len(options) || values[0] != (Value{Key: "listen", Value: "127.0.0.1:2", Source: "env " + EnvPrefix + "LISTEN"}) {
+		t.Error("unexpected values: ", values)
+	}
+}
+
+// Makes sure that repeated list flags are combined and replace the list of the file.
+func TestLoadListFlags(t *testing.T) {
+	path := writeConfig(t, `{"passEnv": "KUBECONFIG"}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	c, err := loadWithArgs(t, path, nil, "-pass-env", "A,B", "-pass-env", "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(c.PassEnv, ",") != "A,B,C" {
+		t.Error("unexpected variables: ", c.PassEnv)
+	}
+}
+
+// Makes sure that the file in the environment is used when no path is given.
+func TestLoadFileFromEnv(t *testing.T) {
+	path := writeConfig(t, `{"backstage": "http://localhost:3000"}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	c, err := Load("", lookupIn(map[string]string{FileEnv: path}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Backstage != "http://localhost:3000" || c.Source("backstage") != "file "+path {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
+
+// Makes sure that invalid values, unknown options and missing files are reported.
+func TestLoadInvalid(t *testing.T) {
+	for _, content := range []string{`{"ui": "gui"}`, `{"color": "red"}`, `{"timeout": 30}`, `{"limits": "disk=1G"}`, `{"passEnv": ["A-B"]}`, `[]`} {
+		path := writeConfig(t, content)
+		if _, err := Load(path, lookupIn(nil), nil); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+		os.RemoveAll(filepath.Dir(path))
+	}
+	if _, err := Load(filepath.Join(os.TempDir(), "missing", FileName), lookupIn(nil), nil); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	_, err := Load("", lookupIn(map[string]string{EnvPrefix + "ENV": "full"}), nil)
+	if err == nil || !strings.Contains(err.Error(), "env "+EnvPrefix+"ENV") {
+		t.Error("expected an error with the variable, got ", err)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How an executed command terminated.
+type Termination string
+
+const (
+	// The command exited by itself, its exit code is in the Result
+	Exited Termination = "exited"
+	// The command was killed because it ran longer than the timeout of the request
+	TimedOut Termination = "timeout"
+	// The command was killed because the execution was cancelled
+	Cancelled Termination = "cancelled"
+	// The command was killed by a signal that was not sent by the hook
+	Signaled Termination = "signaled"
+	// The command was killed because it exceeded its CPU time or file size limit, see Limits
+	LimitExceeded Termination = "limit_exceeded"
+)
+
+// The variables of the environment of the hook that most commands need, these are passed to commands unless configured
+// otherwise. Other variables often contain secrets (eg. GITHUB_TOKEN) that must not leak to the requested commands.
+var MinimalEnv = []string{"HOME", "LANG", "LC_ALL", "LOGNAME", "PATH", "SHELL", "TERM", "TMPDIR", "TZ", "USER"}
+
+// Returned when the executable of an approved action changed between its approval and its execution.
+var ErrExecutableChanged = errors.New("the executable changed since the action was approved")
+
+// Executor runs approved actions. Currently this is implemented by a local executor but sandboxed executors may also be
+// implemented.
+type Executor interface {
+	// Runs the command of the request and blocks until it has exited. Output is written to the writers of the request
+	// as it is produced. A command that exits with a non-zero exit code is not an error, an error is only returned if
+	// the command could not be run at all.
+	Execute(ctx context.Context, req Request) (Result, error)
+	// Resolves the name of the command of the action to the executable that would run it. This is shown to the user
+	// before approving the action, an error is returned if there is no such executable.
+	Resolve(a actions.Action) (actions.Executable, error)
+}
+
+// An approved action and where its output should go.
+type Request struct {
+	// The action to execute
+	Action actions.Action
+	// Receives the standard output of the command, the output is discarded if nil
+	Stdout io.Writer
+	// Receives the standard error of the command, the output is discarded if nil
+	Stderr io.Writer
+	// The command and all of its child processes are killed once it ran this long, there is no timeout if zero
+	Timeout time.Duration
+	// The absolute paths of the directories the command may write to, only used by executors that restrict writes
+	// (see NewSandbox)
+	Writable []string
+	// The resource limits of the command, these are applied before the command is executed
+	Limits Limits
+}
+
+// The outcome of an executed action.
+type Result struct {
+	// Everything the command wrote to its standard output, only set by Capture
+	Stdout string `json:"stdout"`
+	// Everything the command wrote to its standard error, only set by Capture
+	Stderr string `json:"stderr"`
+	// The exit code of the command, -1 if the command did not exit by itself
+	ExitCode int `json:"exitCode"`
+	// How the command terminated
+	Status Termination `json:"status"`
+	// The signal that killed the command (eg. killed), only set if the command did not exit by itself
+	Signal string `json:"signal,omitempty"`
+}
+
+// Executes the request with e and captures the complete output in the Result, the writers of req are ignored.
+func Capture(ctx context.Context, e Executor, req Request) (Result, error) {
+	var stdout, stderr bytes.Buffer
+	req.Stdout, req.Stderr = &stdout, &stderr
+	res, err := e.Execute(ctx, req)
+	res.Stdout, res.Stderr = stdout.String(), stderr.String()
+	return res, err
+}
+
+// Creates an executor that runs commands directly on this machine as the current user.
+func New() Executor {
+	return &localExecutor{}
+}
+
+// Executes the command as a child process of the hook. The command is never passed through a shell, so the arguments
+// are passed to the command exactly as they were approved. The environment of the hook is not inherited, see Environ.
+// The command runs in its own process group, so the processes it starts are killed with it when ctx is done or the
+// timeout expires. Commands with limits are started through a helper process that applies them, see Limits.
+type localExecutor struct{}
+
+// See the Executor interface.
+func (l *localExecutor) Execute(ctx context.Context, req Request) (Result, error) {
+	cmd, err := prepare(req)
+	if err != nil {
+		return Result{}, err
+	}
+	start := cmd.Start
+	if req.Limits == (Limits{}) {
+		setProcessGroup(cmd)
+	} else if start, err = limit(cmd, req.Limits); err != nil {
+		return Result{}, err
+	}
+	return run(ctx, cmd, req, start)
+}
+
+// Prepares the command of the request: the approved executable with its arguments, directory, environment and input.
+func prepare(req Request) (*exec.Cmd, error) {
+	name := req.Action.Command.Name
+	if exe := req.Action.Executable; exe != nil { // Run exactly what was approved, even if PATH changed since
+		if err := verify(*exe); err != nil {
+			return nil, err
+		}
+		name = exe.Path
+	}
+	cmd := exec.Command(name, req.Action.Command.Args...)
+	cmd.Args[0] = req.Action.Command.Name
+	cmd.Dir = req.Action.Command.Dir
+	cmd.Env = Environ(req.Action)
+	if req.Action.Command.Stdin != "" {
+		cmd.Stdin = strings.NewReader(req.Action.Command.Stdin)
+	}
+	cmd.Stdout = req.Stdout
+	cmd.Stderr = req.Stderr
+	return cmd, nil
+}
+
+// Starts the command of the request with start and waits until it exited. The process group of the command is killed
+// when ctx is done or the timeout of the request (if not zero) expires.
+func run(ctx context.Context, cmd *exec.Cmd, req Request, start func() error) (Result, error) {
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+	if err := start(); err != nil {
+		return Result{}, err
+	}
+
+	done, killed := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+			killed <- true
+		case <-done:
+			killed <- false
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	res := terminated(cmd, req.Limits, <-killed, ctx.Err())
+	if _, ok := err.(*exec.ExitError); ok { // The command ran but did not exit successfully, this is in the result
+		err = nil
+	}
+	return res, err
+}
+
+// See the Executor interface.
+func (l *localExecutor) Resolve(a actions.Action) (actions.Executable, error) {
+	return Resolve(a.Command.Name, a.Command.Dir)
+}
+
+// Resolves name to the absolute path of the executable that runs it and computes its digest. Names without a slash
+// are searched for in PATH, like a shell would. Other relative names are relative to dir, or to the working directory
+// of the hook if dir is empty.
+func Resolve(name string, dir string) (actions.Executable, error) {
+	if dir != "" && strings.ContainsRune(name, filepath.Separator) && !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return actions.Executable{}, err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return actions.Executable{}, err
+	}
+	digest, err := digest(path)
+	if err != nil {
+		return actions.Executable{}, err
+	}
+	return actions.Executable{Path: path, Digest: digest}, nil
+}
+
+// Returns the environment of the command of the action: the variables of the hook that are named in PassEnv, followed
+// by the variables that the command sets. No other variables of the hook are passed.
+func Environ(a actions.Action) []string {
+	env := []string{} // Not nil, a nil environment would pass the complete environment of the hook
+	for _, name := range a.PassEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return append(env, a.Command.Environ()...) // Later values take precedence
+}
+
+// Checks that the executable still has the digest it had when it was resolved.
+func verify(exe actions.Executable) error {
+	if exe.Digest == "" {
+		return nil
+	}
+	d, err := digest(exe.Path)
+	if err != nil {
+		return err
+	}
+	if d != exe.Digest {
+		return ErrExecutableChanged
+	}
+	return nil
+}
+
+// Returns the hex encoded sha256 of the contents of the file at path.
+func digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns the result of a command with limits that has been waited for. killed tells whether the hook killed the
+// command because ctx was done with ctxErr.
+func terminated(cmd *exec.Cmd, limits Limits, killed bool, ctxErr error) Result {
+	state := cmd.ProcessState
+	res := Result{ExitCode: state.ExitCode(), Status: Exited}
+	if signal := exitSignal(state); signal != "" {
+		res.Status, res.Signal = Signaled, signal
+		if limitExceeded(state, limits) {
+			res.Status = LimitExceeded
+		}
+	}
+	if killed && !state.Success() { // The command may have exited by itself just before it was killed
+		res.Status = Cancelled
+		if ctxErr == context.DeadlineExceeded {
+			res.Status = TimedOut
+		}
+	}
+	return res
+}
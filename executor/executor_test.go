@@ -0,0 +1,217 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func command(name string, args ...string) actions.Action {
+	return actions.Action{Plugin: "testplugin", Command: actions.Command{Name: name, Args: args}}
+}
+
+// Makes sure that stdout, stderr and the exit code are captured.
+func TestExecute(t *testing.T) {
+	res, err := Capture(context.Background(), New(), Request{Action: command("sh", "-c", "echo out; echo err >&2; exit 3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "out\n" || res.Stderr != "err\n" || res.ExitCode != 3 || res.Status != Exited {
+		t.Error("unexpected result: ", res)
+	}
+}
+
+// Makes sure that arguments are not interpreted by a shell.
+func TestNoShell(t *testing.T) {
+	res, err := Capture(context.Background(), New(), Request{Action: command("echo", "$HOME", ";", "ls", "*")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "$HOME ; ls *\n" || res.ExitCode != 0 {
+		t.Error("arguments were altered: ", res.Stdout)
+	}
+}
+
+// Makes sure that an error is returned when the command does not exist.
+func TestCommandNotFound(t *testing.T) {
+	_, err := New().Execute(context.Background(), Request{Action: command("backstage-hook-nonexistent-command")})
+	if err == nil {
+		t.Error("expected an error for a nonexistent command")
+	}
+}
+
+// Makes sure that output is written to the writers of the request while the command is running.
+func TestStreamingOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	req := Request{Action: command("sh", "-c", "echo out; echo err >&2"), Stdout: &stdout, Stderr: &stderr}
+	res, err := New().Execute(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" || res.Stdout != "" || res.Stderr != "" {
+		t.Error("output was not written to the request writers")
+	}
+}
+
+// Makes sure that a command is killed with its child processes once the timeout expires.
+func TestTimeout(t *testing.T) {
+	var stdout bytes.Buffer
+	// The child process keeps stdout open, waiting for the output would hang if it was not killed
+	req := Request{Action: command("sh", "-c", "(sleep 10; echo late) & sleep 10"), Stdout: &stdout, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	res, err := New().Execute(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the child process of the command was not killed")
+	}
+	if res.Status != TimedOut || res.ExitCode != -1 || res.Signal == "" {
+		t.Error("unexpected result: ", res)
+	}
+}
+
+// Makes sure that a cancelled execution is reported as cancelled.
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	res, err := New().Execute(ctx, Request{Action: command("sleep", "10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != Cancelled || res.ExitCode != -1 {
+		t.Error("unexpected result: ", res)
+	}
+}
+
+// Makes sure that a command killed by another signal is not reported as timed out or cancelled.
+func TestSignaled(t *testing.T) {
+	res, err := New().Execute(context.Background(), Request{Action: command("sh", "-c", "kill -TERM $$"), Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != Signaled || res.Signal != "terminated" {
+		t.Error("unexpected result: ", res)
+	}
+}
+
+// Makes sure that a command is resolved to an absolute path with the digest of its contents.
+func TestResolve(t *testing.T) {
+	exe, err := Resolve("sh", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(exe.Path) || len(exe.Digest) != 64 {
+		t.Error("unexpected executable: ", exe)
+	}
+	if _, err := Resolve("backstage-hook-nonexistent-command", ""); err == nil {
+		t.Error("expected an error for a nonexistent command")
+	}
+}
+
+// Makes sure that the approved executable is run, and that it is not run once it was replaced.
+func TestExecutableChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backstage-hook-executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "script")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\necho approved\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	exe, err := Resolve(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := command("script") // Not in PATH, only the resolved executable can run it
+	a.Executable = &exe
+	res, err := Capture(context.Background(), New(), Request{Action: a})
+	if err != nil || res.Stdout != "approved\n" {
+		t.Fatal("the resolved executable was not run: ", res, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\necho replaced\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New().Execute(context.Background(), Request{Action: a}); err != ErrExecutableChanged {
+		t.Error("expected ErrExecutableChanged, got ", err)
+	}
+}
+
+// Makes sure that the command runs in its directory with its environment and input.
+func TestCommandContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backstage-hook-executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	a := command("sh", "-c", "pwd; echo $GREETING; cat")
+	a.Command.Dir, a.Command.Env, a.Command.Stdin = dir, map[string]string{"GREETING": "hello"}, "input"
+	res, err := Capture(context.Background(), New(), Request{Action: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != dir+"\nhello\ninput" {
+		t.Error("unexpected output: ", res.Stdout)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "script"), []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if exe, err := Resolve("./script", dir); err != nil || exe.Path != filepath.Join(dir, "script") {
+		t.Error("relative command was not resolved in its directory: ", exe, err)
+	}
+}
+
+// Makes sure that only the passed variables of the environment of the hook reach the command.
+func TestEnvironment(t *testing.T) {
+	os.Setenv("BACKSTAGE_HOOK_SECRET", "secret")
+	os.Setenv("BACKSTAGE_HOOK_PASSED", "passed")
+	defer os.Unsetenv("BACKSTAGE_HOOK_SECRET")
+	defer os.Unsetenv("BACKSTAGE_HOOK_PASSED")
+
+	a := command("sh", "-c", `echo "$BACKSTAGE_HOOK_SECRET|$BACKSTAGE_HOOK_PASSED|$OWN"`)
+	a.PassEnv = []string{"BACKSTAGE_HOOK_PASSED", "BACKSTAGE_HOOK_UNSET"}
+	a.Command.Env = map[string]string{"OWN": "own"}
+	res, err := Capture(context.Background(), New(), Request{Action: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "|passed|own\n" {
+		t.Error("unexpected environment: ", res.Stdout)
+	}
+	if env := Environ(command("env")); env == nil || len(env) != 0 {
+		t.Error("an action without passed variables should get an empty environment: ", env)
+	}
+}
@@ -0,0 +1,216 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const (
+	// The first argument of the helper process that prepares the command before executing it, see init
+	helperName = "backstage-hook-helper"
+	// The exit code of the helper when the command could not be prepared, the reason is sent through the error pipe
+	helperFailed = 127
+	// The file descriptor of the error pipe in the helper
+	helperErrorFd = 3
+)
+
+// What the helper needs to know to prepare and execute the command.
+type helperConfig struct {
+	// The absolute path of the executable
+	Path string
+	// The arguments of the command, including the name of the command
+	Args []string
+	// The directory the command runs in, empty to keep the directory of the helper
+	Dir string
+	// Whether the command runs in the sandbox, the helper must be started in new namespaces (see sandbox)
+	Sandbox bool
+	// The directories that are writable in the sandbox
+	Writable []string
+	// The resource limits of the command
+	Limits Limits
+}
+
+// Every binary that contains this package can act as the helper: the executor runs its own executable with
+// helperName as the first argument. The helper prepares the process (eg. sets up the sandbox) and replaces itself by
+// the command, it never returns to the program.
+func init() {
+	if len(os.Args) == 2 && os.Args[0] == helperName {
+		runHelper(os.Args[1])
+	}
+}
+
+// Turns cmd into a helper process that applies the limits before executing the command.
+func limit(cmd *exec.Cmd, limits Limits) (func() error, error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return helper(cmd, helperConfig{Limits: limits})
+}
+
+// Turns cmd into a helper process that prepares the command as described by c. The path, arguments and directory of
+// c are taken from cmd. The returned function starts the helper and returns an error if the command could not be
+// prepared.
+func helper(cmd *exec.Cmd, c helperConfig) (func() error, error) {
+	c.Path, c.Args, c.Dir = cmd.Path, cmd.Args, cmd.Dir
+	if !filepath.IsAbs(c.Path) {
+		path, err := exec.LookPath(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		if c.Path, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+	}
+	self, err := executable()
+	if err != nil {
+		return nil, err
+	}
+	config, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Path = self
+	cmd.Args = []string{helperName, string(config)}
+	cmd.Dir = ""
+	return func() error {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		cmd.ExtraFiles = []*os.File{w}
+		err = cmd.Start()
+		w.Close()
+		if err != nil {
+			return err
+		}
+		// The pipe is closed without a message once the helper replaced itself by the command
+		msg, _ := ioutil.ReadAll(r)
+		if len(msg) == 0 {
+			return nil
+		}
+		_ = cmd.Wait()
+		return fmt.Errorf("could not prepare the command: %s", msg)
+	}, nil
+}
+
+// Returns the path of the executable of the hook. On Linux this is /proc/self/exe, which still works when the
+// executable was replaced (eg. by an update) while the hook runs.
+func executable() (string, error) {
+	if runtime.GOOS == "linux" {
+		return "/proc/self/exe", nil
+	}
+	return os.Executable()
+}
+
+// Prepares the command in the helper process and executes it. Errors are written to the error pipe.
+func runHelper(config string) {
+	runtime.LockOSThread()
+	errPipe := os.NewFile(helperErrorFd, "errors")
+	syscall.CloseOnExec(helperErrorFd)
+
+	var c helperConfig
+	err := json.Unmarshal([]byte(config), &c)
+	if err == nil && c.Sandbox {
+		err = enterSandbox(c.Writable, c.Dir)
+	}
+	if err == nil && c.Dir != "" {
+		err = os.Chdir(c.Dir)
+	}
+	if err == nil {
+		err = setLimits(c.Limits)
+	}
+	if err == nil {
+		err = syscall.Exec(c.Path, c.Args, os.Environ())
+	}
+	fmt.Fprint(errPipe, err)
+	os.Exit(helperFailed)
+}
+
+// Sets the resource limits of the current process, they are inherited by the command. The CPU limit is a soft limit
+// with a hard limit a second later: the command receives SIGXCPU first and is killed if it ignores it.
+func setLimits(l Limits) error {
+	limits := []struct {
+		name     string
+		resource int
+		soft     uint64
+		hard     uint64
+	}{
+		{"cpu", syscall.RLIMIT_CPU, l.CPU, l.CPU + 1},
+		{"memory", rlimitMemory, uint64(l.Memory), uint64(l.Memory)},
+		{"openFiles", syscall.RLIMIT_NOFILE, l.OpenFiles, l.OpenFiles},
+		{"fileSize", syscall.RLIMIT_FSIZE, uint64(l.FileSize), uint64(l.FileSize)},
+		{"processes", rlimitProcesses, l.Processes, l.Processes},
+	}
+	for _, limit := range limits {
+		if limit.soft == 0 {
+			continue
+		}
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(limit.resource, &current); err != nil {
+			return fmt.Errorf("limit %s: %v", limit.name, err)
+		}
+		if max := hardLimit(current); limit.hard > max { // Only privileged processes can raise the hard limit
+			limit.hard = max
+		}
+		if limit.soft > limit.hard {
+			limit.soft = limit.hard
+		}
+		rlimit := newRlimit(limit.soft, limit.hard)
+		if err := syscall.Setrlimit(limit.resource, &rlimit); err != nil {
+			return fmt.Errorf("limit %s: %v", limit.name, err)
+		}
+	}
+	return nil
+}
+
+// Whether the process was killed because it exceeded its CPU time or file size limit. The other limits make system
+// calls fail instead, the command decides how it handles that.
+func limitExceeded(state *os.ProcessState, l Limits) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return l.CPU != 0
+	case syscall.SIGXFSZ:
+		return l.FileSize != 0
+	case syscall.SIGKILL: // The hard CPU limit, if the command ignored SIGXCPU
+		return l.CPU != 0 && state.UserTime()+state.SystemTime() >= time.Duration(l.CPU)*time.Second
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Returned when limits are requested on a platform that does not support them.
+var ErrLimitsUnsupported = errors.New("resource limits are not supported on this platform")
+
+// The suffixes of sizes, in increasing order. Every suffix is 1024 times the previous one.
+const sizeSuffixes = "KMGT"
+
+// Resource limits for an executed command, they apply to the command and to every process it starts. Zero fields are
+// not limited.
+type Limits struct {
+	// The CPU time of every process in seconds, a process that uses more is killed
+	CPU uint64 `json:"cpu,omitempty"`
+	// The size of the address space of every process in bytes, allocations beyond it fail
+	Memory Size `json:"memory,omitempty"`
+	// The number of files every process can have open, opening more fails
+	OpenFiles uint64 `json:"openFiles,omitempty"`
+	// The size of every file a process writes in bytes, a process that writes beyond it is killed
+	FileSize Size `json:"fileSize,omitempty"`
+	// The number of processes of the user, including the ones that already run outside of the command. Starting more
+	// fails.
+	Processes uint64 `json:"processes,omitempty"`
+}
+
+// Parses limits in the format of Limits.String, eg. cpu=60,memory=2G,processes=256.
+func ParseLimits(s string) (Limits, error) {
+	var l Limits
+	for _, limit := range strings.Split(s, ",") {
+		limit = strings.TrimSpace(limit)
+		if limit == "" {
+			continue
+		}
+		parts := strings.SplitN(limit, "=", 2)
+		if len(parts) != 2 {
+			return Limits{}, fmt.Errorf("invalid limit %q, expected name=value", limit)
+		}
+		name, value := parts[0], parts[1]
+		var err error
+		switch name {
+		case "cpu":
+			l.CPU, err = strconv.ParseUint(value, 10, 64)
+		case "memory":
+			l.Memory, err = ParseSize(value)
+		case "openFiles":
+			l.OpenFiles, err = strconv.ParseUint(value, 10, 64)
+		case "fileSize":
+			l.FileSize, err = ParseSize(value)
+		case "processes":
+			l.Processes, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return Limits{}, fmt.Errorf("unknown limit %q, expected cpu, memory, openFiles, fileSize or processes", name)
+		}
+		if err != nil {
+			return Limits{}, fmt.Errorf("limit %s: invalid value %q", name, value)
+		}
+	}
+	return l, nil
+}
+
+// Returns the stricter limits of l and o: for every resource the lowest limit, unless one of them is not limited.
+func (l Limits) Min(o Limits) Limits {
+	return Limits{
+		CPU:       minLimit(l.CPU, o.CPU),
+		Memory:    Size(minLimit(uint64(l.Memory), uint64(o.Memory))),
+		OpenFiles: minLimit(l.OpenFiles, o.OpenFiles),
+		FileSize:  Size(minLimit(uint64(l.FileSize), uint64(o.FileSize))),
+		Processes: minLimit(l.Processes, o.Processes),
+	}
+}
+
+// Returns the lowest of both limits, ignoring zero (no limit).
+func minLimit(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// The limits as comma separated name=value pairs (eg. cpu=60,memory=2G), resources that are not limited are left out.
+func (l Limits) String() string {
+	var limits []string
+	if l.CPU != 0 {
+		limits = append(limits, fmt.Sprintf("cpu=%d", l.CPU))
+	}
+	if l.Memory != 0 {
+		limits = append(limits, "memory="+l.Memory.String())
+	}
+	if l.OpenFiles != 0 {
+		limits = append(limits, fmt.Sprintf("openFiles=%d", l.OpenFiles))
+	}
+	if l.FileSize != 0 {
+		limits = append(limits, "fileSize="+l.FileSize.String())
+	}
+	if l.Processes != 0 {
+		limits = append(limits, fmt.Sprintf("processes=%d", l.Processes))
+	}
+	return strings.Join(limits, ",")
+}
+
+// A size in bytes. In JSON it is a number of bytes or a string in the format of ParseSize.
+type Size uint64
+
+// Parses a number of bytes, optionally followed by K, M, G or T for kibibytes, mebibytes, gibibytes or tebibytes.
+func ParseSize(s string) (Size, error) {
+	size, multiplier := s, uint64(1)
+	if s != "" {
+		if i := strings.IndexByte(sizeSuffixes, s[len(s)-1]); i >= 0 {
+			multiplier = 1 << (10 * uint(i+1))
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > (1<<64-1)/multiplier {
+		return 0, fmt.Errorf("size %s is too large", size)
+	}
+	return Size(n * multiplier), nil
+}
+
+// The size with the largest suffix that represents it exactly, eg. 2G.
+func (s Size) String() string {
+	n, suffix := uint64(s), ""
+	for i := 0; i < len(sizeSuffixes) && n != 0 && n%1024 == 0; i++ {
+		n, suffix = n/1024, sizeSuffixes[i:i+1]
+	}
+	return strconv.FormatUint(n, 10) + suffix
+}
+
+// Accepts a number of bytes or a string in the format of ParseSize.
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		var n uint64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid size %s, expected a number of bytes or eg. \"512M\"", data)
+		}
+		*s = Size(n)
+		return nil
+	}
+	size, err := ParseSize(str)
+	if err != nil {
+		return fmt.Errorf("invalid size %q, expected a number of bytes or eg. \"512M\"", str)
+	}
+	*s = size
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Makes sure that limits are parsed and formatted in the same format.
+func TestParseLimits(t *testing.T) {
+	l, err := ParseLimits("cpu=60, memory=2G,openFiles=64,fileSize=1536K,processes=256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Limits{CPU: 60, Memory: 2 << 30, OpenFiles: 64, FileSize: 1536 << 10, Processes: 256}
+	if l != expected {
+		t.Error("unexpected limits: ", l)
+	}
+	if l.String() != "cpu=60,memory=2G,openFiles=64,fileSize=1536K,processes=256" {
+		t.Error("unexpected string: ", l.String())
+	}
+	if l, err := ParseLimits(""); err != nil || l != (Limits{}) {
+		t.Error("expected no limits, got ", l, err)
+	}
+	for _, invalid := range []string{"cpu", "cpu=-1", "memory=2X", "memory=20000000T", "disk=1G"} {
+		if _, err := ParseLimits(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+// Makes sure that sizes can be numbers or strings in JSON.
+func TestSizeJSON(t *testing.T) {
+	var l Limits
+	if err := json.Unmarshal([]byte(`{"memory": "512M", "fileSize": 1000}`), &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Memory != 512<<20 || l.FileSize != 1000 {
+		t.Error("unexpected limits: ", l)
+	}
+	if err := json.Unmarshal([]byte(`{"memory": "lots"}`), &l); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+}
+
+// Makes sure that the strictest limit of both is used for every resource.
+func TestLimitsMin(t *testing.T) {
+	global := Limits{CPU: 60, Memory: 1 << 30}
+	rule := Limits{CPU: 10, Memory: 2 << 30, Processes: 100}
+	if min := global.Min(rule); min != (Limits{CPU: 10, Memory: 1 << 30, Processes: 100}) {
+		t.Error("unexpected limits: ", min)
+	}
+	if min := global.Min(Limits{}); min != global {
+		t.Error("unexpected limits: ", min)
+	}
+}
+
+// Makes sure that the limits are applied to the command.
+func TestLimitsApplied(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("resource limits are not supported on Windows")
+	}
+	limits := Limits{CPU: 30, OpenFiles: 32}
+	res, err := Capture(context.Background(), New(), Request{Action: command("sh", "-c", "ulimit -t; ulimit -n"), Limits: limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "30\n32\n" || res.Status != Exited {
+		t.Error("the limits were not applied: ", res)
+	}
+}
+
+// Makes sure that a command that exceeds a limit is reported as such.
+func TestLimitExceeded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("resource limits are not supported on Windows")
+	}
+	dir, err := ioutil.TempDir("", "backstage-hook-limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := command("dd", "if=/dev/zero", "of="+filepath.Join(dir, "out"), "bs=1024", "count=16")
+	res, err := Capture(context.Background(), New(), Request{Action: a, Limits: Limits{FileSize: 4 << 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != LimitExceeded {
+		t.Error("expected the file size limit to be exceeded: ", res)
+	}
+
+	res, err = Capture(context.Background(), New(), Request{Action: command("sh", "-c", "while :; do :; done"), Limits: Limits{CPU: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != LimitExceeded {
+		t.Error("expected the CPU limit to be exceeded: ", res)
+	}
+
+	res, err = Capture(context.Background(), New(), Request{Action: command("sh", "-c", "kill -XFSZ $$")})
+	if err != nil || res.Status != Signaled {
+		t.Error("a command without limits can not exceed them: ", res, err)
+	}
+}
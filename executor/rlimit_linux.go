@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import "syscall"
+
+const (
+	// The resource that limits the memory of a process
+	rlimitMemory = syscall.RLIMIT_AS
+	// The resource that limits the number of processes of a user, RLIMIT_NPROC is not defined by the syscall package
+	rlimitProcesses = 6
+)
+
+// Creates an Rlimit, its fields have different types on different platforms.
+func newRlimit(soft, hard uint64) syscall.Rlimit {
+	return syscall.Rlimit{Cur: soft, Max: hard}
+}
+
+// Returns the hard limit of r.
+func hardLimit(r syscall.Rlimit) uint64 {
+	return r.Max
+}
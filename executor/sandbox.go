@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"github.com/tcorp-bv/backstage-hook/actions"
+)
+
+const (
+	// The name of the executor that runs commands directly on this machine, see New
+	Local = "local"
+	// The name of the executor that runs commands in a sandbox, see NewSandbox
+	Sandbox = "sandbox"
+)
+
+// Returned by the sandbox on platforms that do not support it.
+var ErrSandboxUnsupported = errors.New("the sandbox is only supported on Linux")
+
+// Whether name is the name of an executor that is provided by this package.
+func Known(name string) bool {
+	return name == Local || name == Sandbox
+}
+
+// Creates an executor that runs commands in a sandbox, for actions of plugins that are not trusted. The command runs in
+// new user, mount, PID and network namespaces: it sees the file system of this machine read-only except for the
+// writable directories of the request and a private /tmp, it does not see the other processes of the machine and it
+// has no network access. No external tools are needed, but the kernel must allow unprivileged user namespaces. On
+// platforms other than Linux every execution fails with ErrSandboxUnsupported.
+func NewSandbox() Executor {
+	return &sandboxExecutor{}
+}
+
+// Executes the command like the local executor, but through a helper process that first enters the sandbox.
+type sandboxExecutor struct{}
+
+// See the Executor interface.
+func (s *sandboxExecutor) Execute(ctx context.Context, req Request) (Result, error) {
+	cmd, err := prepare(req)
+	if err != nil {
+		return Result{}, err
+	}
+	start, err := sandbox(cmd, req.Writable, req.Limits)
+	if err != nil {
+		return Result{}, err
+	}
+	return run(ctx, cmd, req, start)
+}
+
+// See the Executor interface.
+func (s *sandboxExecutor) Resolve(a actions.Action) (actions.Executable, error) {
+	return Resolve(a.Command.Name, a.Command.Dir)
+}
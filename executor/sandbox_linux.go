@@ -0,0 +1,235 @@
+//go:build linux
+// +build linux
+
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// Where the host root and the new root are mounted while the sandbox is set up, on a tmpfs
+	sandboxOldRoot = "/oldroot"
+	sandboxNewRoot = "/newroot"
+	// prctl option that keeps the command from gaining privileges, eg. through setuid binaries
+	prSetNoNewPrivs = 38
+)
+
+// The flags of a mount as reported by statfs (ST_*) and the mount flags (MS_*) to keep them when remounting.
+var lockedFlags = map[int64]uintptr{
+	0x2:    syscall.MS_NOSUID,
+	0x4:    syscall.MS_NODEV,
+	0x8:    syscall.MS_NOEXEC,
+	0x400:  syscall.MS_NOATIME,
+	0x800:  syscall.MS_NODIRATIME,
+	0x1000: syscall.MS_RELATIME,
+}
+
+// Turns cmd into a helper process that runs the command in the sandbox with the limits. The helper starts in new
+// namespaces and sets up the sandbox before executing the command, see enterSandbox. The returned function starts the
+// helper and returns an error if the sandbox could not be set up.
+func sandbox(cmd *exec.Cmd, writable []string, limits Limits) (func() error, error) {
+	if cmd.Dir == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Dir = dir
+	}
+	for _, dir := range writable {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("writable directory %q is not an absolute path", dir)
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:     true,
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	return helper(cmd, helperConfig{Sandbox: true, Writable: writable, Limits: limits})
+}
+
+// Builds the file system of the sandbox and makes it the root, in the helper process. The helper is root in its user
+// namespace, which only gives it power over the namespaces it created. The directory the command runs in stays visible
+// if the private /tmp hides it, read-only unless it is writable.
+func enterSandbox(writable []string, dir string) error {
+	// Nothing that is mounted here may propagate to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %v", err)
+	}
+	// Build the new root on a tmpfs, the host root stays reachable under sandboxOldRoot until it is complete
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0700"); err != nil {
+		return fmt.Errorf("mount tmpfs: %v", err)
+	}
+	for _, dir := range []string{"/tmp" + sandboxOldRoot, "/tmp" + sandboxNewRoot} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := syscall.PivotRoot("/tmp", "/tmp"+sandboxOldRoot); err != nil {
+		return fmt.Errorf("pivot root: %v", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+
+	// The new root is the host root, read-only
+	if err := syscall.Mount(sandboxOldRoot, sandboxNewRoot, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind the root: %v", err)
+	}
+	if err := remountReadOnly(sandboxNewRoot); err != nil {
+		return err
+	}
+	// A /proc that only shows the processes of the sandbox and a private /tmp
+	if err := syscall.Mount("proc", sandboxNewRoot+"/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %v", err)
+	}
+	if err := syscall.Mount("tmpfs", sandboxNewRoot+"/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %v", err)
+	}
+	for _, dir := range writable {
+		target := sandboxNewRoot + dir
+		_ = os.MkdirAll(target, 0700) // Only possible (and needed) below the private /tmp, the rest is read-only
+		if err := syscall.Mount(sandboxOldRoot+dir, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind writable directory %s: %v", dir, err)
+		}
+	}
+	if dir = filepath.Clean(dir); under(dir, "/tmp") && !underAny(dir, writable) {
+		target := sandboxNewRoot + dir
+		_ = os.MkdirAll(target, 0700)
+		if err := syscall.Mount(sandboxOldRoot+dir, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind the working directory %s: %v", dir, err)
+		}
+		if err := remountReadOnly(target); err != nil {
+			return err
+		}
+	}
+
+	// Switch to the new root and detach the host root
+	if err := os.Chdir(sandboxNewRoot); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot root: %v", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach the host root: %v", err)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("set no new privileges: %v", errno)
+	}
+	return nil
+}
+
+// Whether path is dir or below it.
+func under(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// Whether path is one of dirs or below one of them.
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if under(path, filepath.Clean(dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remounts every mount at or below root read-only. The flags that are locked in a user namespace (eg. nosuid) must
+// be kept, so they are read from the mount first.
+func remountReadOnly(root string) error {
+	mounts, err := mountPoints(sandboxOldRoot+"/proc/self/mountinfo", root)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m, &st); err != nil {
+			if err == syscall.EACCES || err == syscall.ENOENT {
+				continue // Covered by another mount or not reachable, so it can not be written through either
+			}
+			return fmt.Errorf("stat mount %s: %v", m, err)
+		}
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		for statFlag, mountFlag := range lockedFlags {
+			if int64(st.Flags)&statFlag != 0 {
+				flags |= mountFlag
+			}
+		}
+		if err := syscall.Mount("", m, "", flags, ""); err != nil {
+			return fmt.Errorf("make %s read-only: %v", strings.TrimPrefix(m, root), err)
+		}
+	}
+	return nil
+}
+
+// Returns the mount points at or below root, as listed in the mountinfo file of a process.
+func mountPoints(mountinfo string, root string) ([]string, error) {
+	f, err := os.Open(mountinfo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		m := unescapeMountPoint(fields[4])
+		if under(m, root) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// Replaces the octal escapes in a mount point of mountinfo (eg. \040 for a space) by the characters.
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs the action in the sandbox, skips the test if the sandbox is not available on this machine.
+func runSandboxed(t *testing.T, a actions.Action, writable ...string) Result {
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox is only supported on Linux")
+	}
+	a.PassEnv = []string{"PATH"}
+	res, err := Capture(context.Background(), NewSandbox(), Request{Action: a, Writable: writable})
+	if err != nil && !strings.Contains(err.Error(), "could not prepare the command") {
+		t.Skip("user namespaces are not available: ", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// Makes sure that the file system is read-only, except for the writable directories and a private /tmp.
+func TestSandboxFileSystem(t *testing.T) {
+	writable, err := ioutil.TempDir("", "backstage-hook-writable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(writable)
+	readOnly, err := ioutil.TempDir(".", "backstage-hook-read-only") // In the working directory, which stays visible
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readOnly, err = filepath.Abs(readOnly); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(readOnly)
+	if err := ioutil.WriteFile(filepath.Join(readOnly, "input"), []byte("visible"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	scratch := fmt.Sprintf("/tmp/backstage-hook-scratch-%d", time.Now().UnixNano())
+	script := "cat " + readOnly + "/input; touch " + writable + "/out; touch " + readOnly + "/out || echo read-only; touch " + scratch + " && echo scratch"
+	res := runSandboxed(t, command("sh", "-c", script), writable)
+	if res.Stdout != "visibleread-only\nscratch\n" {
+		t.Error("unexpected output: ", res.Stdout, res.Stderr)
+	}
+	if _, err := os.Stat(filepath.Join(writable, "out")); err != nil {
+		t.Error("the writable directory could not be written to: ", res.Stderr)
+	}
+	if _, err := os.Stat(filepath.Join(readOnly, "out")); err == nil {
+		t.Error("a directory outside of the writable directories was written to")
+	}
+	if _, err := os.Stat(scratch); err == nil {
+		t.Error("the sandbox wrote to the /tmp of the host")
+	}
+}
+
+// Makes sure that a command runs in a directory below the /tmp of the host, which the private /tmp hides otherwise.
+func TestSandboxWorkingDirInTmp(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "backstage-hook-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "input"), []byte("visible"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := command("sh", "-c", "pwd; cat input; touch out 2>/dev/null || echo read-only")
+	a.Command.Dir = dir
+	if res := runSandboxed(t, a); res.Stdout != dir+"\nvisibleread-only\n" {
+		t.Error("unexpected output: ", res.Stdout, res.Stderr)
+	}
+	a = command("sh", "-c", "touch out && echo written")
+	a.Command.Dir = dir
+	if res := runSandboxed(t, a, dir); res.Stdout != "written\n" {
+		t.Error("the writable working directory could not be written to: ", res.Stdout, res.Stderr)
+	}
+}
+
+// Makes sure that the command does not see the other processes of the machine, nor its network.
+func TestSandboxIsolation(t *testing.T) {
+	res := runSandboxed(t, command("sh", "-c", "echo $$; cat /proc/1/comm; grep -c : /proc/net/dev"))
+	if res.Stdout != "1\nsh\n1\n" {
+		t.Error("the command is not isolated: ", res.Stdout, res.Stderr)
+	}
+}
+
+// Makes sure that the sandbox reports when it can not be set up, and that it can be cancelled like any command.
+func TestSandboxErrors(t *testing.T) {
+	runSandboxed(t, command("true"))
+	_, err := NewSandbox().Execute(context.Background(), Request{Action: command("true"), Writable: []string{"/backstage-hook-nonexistent"}})
+	if err == nil || !strings.Contains(err.Error(), "backstage-hook-nonexistent") {
+		t.Error("expected an error for a nonexistent writable directory, got ", err)
+	}
+
+	start := time.Now()
+	res, err := NewSandbox().Execute(context.Background(), Request{Action: command("sh", "-c", "sleep 10 & sleep 10"), Timeout: 100 * time.Millisecond})
+	if err != nil || res.Status != TimedOut || time.Since(start) > 5*time.Second {
+		t.Error("the sandboxed command was not killed: ", res, err)
+	}
+}
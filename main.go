@@ -24,6 +24,27 @@
 
 package main
 
+import (
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"log"
+	"os"
+)
+
 func main() {
-	
+	app := cli.NewApp()
+	app.Usage = "Allows Backstage plugins to execute commands on your machine"
+	app.ArgsUsage = app.Name + " command [arguments...]"
+	app.Flags = []cli.Flag{configFlag}
+	app.Commands = []*cli.Command{
+		startCommand,
+		auditCommand,
+		policyCommand,
+		configCommand,
+		cli.NewCompletionCommand(),
+	}
+
+	err := app.Run(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 }
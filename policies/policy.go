@@ -26,20 +26,29 @@ package policies
 
 import (
 	"errors"
+	"time"
 )
 
 var (
 	// Policy to allow an action once
-	allow = policy{id: "ALLOW", name: "Allow", description: "Allow the action once", shortcut: "a"}
+	allow = policy{id: "ALLOW", name: "Allow", description: "Allow the action once", shortcut: "a", allows: true}
+	// Policy to allow the action for the next 15 minutes
+	allowFor15Minutes = policy{id: "ALLOW_15M", name: "Allow for 15 minutes", description: "Allow this action for the next 15 minutes", shortcut: "m", allows: true, duration: 15 * time.Minute}
+	// Policy to allow the action until the hook restarts
+	allowUntilRestart = policy{id: "ALLOW_UNTIL_RESTART", name: "Allow until restart", description: "Allow this action until the hook restarts", shortcut: "u", allows: true, untilRestart: true}
 	// Policy to always allow the action in the future
-	allowAlways = policy{id: "ALLOW_ALWAYS", name: "Always allow", description: "Always allow this action", shortcut: "s"}
+	allowAlways = policy{id: "ALLOW_ALWAYS", name: "Always allow", description: "Always allow this action", shortcut: "s", allows: true}
+	// Policy to allow this and similar actions in the future through a generated rule
+	allowSimilar = policy{id: "ALLOW_SIMILAR", name: "Allow similar", description: "Always allow this and similar actions", shortcut: "r", allows: true}
 	// Policy to deny this action this time
 	deny = policy{id: "DENY", name: "Deny", description: "Deny this action this time", shortcut: "d"}
+	// Policy to always deny the action in the future without asking
+	denyAlways = policy{id: "DENY_ALWAYS", name: "Always deny", description: "Always deny this action without asking", shortcut: "x"}
 )
 
-// Returns a list containing allow, allowAlways and deny.
+// Returns all policies: allow, allowFor15Minutes, allowUntilRestart, allowAlways, allowSimilar, deny and denyAlways.
 func All() []Policy {
-	return []Policy{Allow(), AllowAlways(), Deny()}
+	return []Policy{Allow(), AllowFor15Minutes(), AllowUntilRestart(), AllowAlways(), AllowSimilar(), Deny(), DenyAlways()}
 }
 
 // Allow is the policy to allow an action once.
@@ -47,17 +56,37 @@ func Allow() Policy {
 	return allow
 }
 
+// AllowFor15Minutes is the policy to allow the action for the next 15 minutes.
+func AllowFor15Minutes() Policy {
+	return allowFor15Minutes
+}
+
+// AllowUntilRestart is the policy to allow the action until the hook restarts.
+func AllowUntilRestart() Policy {
+	return allowUntilRestart
+}
+
 // AllowAlways is the policy to always allow the action in the future.
 func AllowAlways() Policy {
 	return allowAlways
 }
 
+// AllowSimilar is the policy to always allow the action and similar actions in the future (see rules.FromAction).
+func AllowSimilar() Policy {
+	return allowSimilar
+}
+
 // Deny is the policy to deny the action this time.
 func Deny() Policy {
 	return deny
 }
 
-// Check if one of the three policies has the given shortcut (which is a single character id used in the cli).
+// DenyAlways is the policy to always deny the action in the future, the user is not asked again.
+func DenyAlways() Policy {
+	return denyAlways
+}
+
+// Check if one of the policies has the given shortcut (which is a single character id used in the cli).
 func ShortcutValid(sc string) bool {
 	for _, pol := range All() {
 		if pol.Shortcut() == sc {
@@ -67,7 +96,7 @@ func ShortcutValid(sc string) bool {
 	return false
 }
 
-// Check if one of the three policies has the given Id.
+// Check if one of the policies has the given Id.
 func IdValid(id string) bool {
 	for _, pol := range All() {
 		if pol.Id() == id {
@@ -107,6 +136,12 @@ type Policy interface {
 	Description() string
 	// One character shortcut that can be used for the command line as an id
 	Shortcut() string
+	// Whether the action may be executed under this policy
+	Allows() bool
+	// How long a stored decision applies to future actions, zero if it does not expire
+	Duration() time.Duration
+	// Whether a stored decision is forgotten when the hook restarts
+	UntilRestart() bool
 }
 
 // The private implementation for Policy. This makes the fields immutable by other packages.
@@ -119,6 +154,12 @@ type policy struct {
 	description string
 	// One letter shortcut that can be used for the command line
 	shortcut string
+	// Whether the action may be executed under this policy
+	allows bool
+	// How long a stored decision applies, zero if it does not expire
+	duration time.Duration
+	// Whether a stored decision is forgotten when the hook restarts
+	untilRestart bool
 }
 
 // See the Policy interface.
@@ -140,3 +181,18 @@ func (p policy) Description() string {
 func (p policy) Shortcut() string {
 	return p.shortcut
 }
+
+// See the Policy interface.
+func (p policy) Allows() bool {
+	return p.allows
+}
+
+// See the Policy interface.
+func (p policy) Duration() time.Duration {
+	return p.duration
+}
+
+// See the Policy interface.
+func (p policy) UntilRestart() bool {
+	return p.untilRestart
+}
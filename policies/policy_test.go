@@ -26,6 +26,7 @@ package policies
 
 import (
 	"testing"
+	"time"
 )
 
 // Tests that byId and byShortcut return the correct policies for all policies in All().
@@ -70,7 +71,7 @@ func TestValidity(t *testing.T) {
 
 // Tests that All() contains Allow(), Deny() and AllowAlways() exactly once.
 func TestPoliciesContents(t *testing.T) {
-	var numAllow, numDeny, numAllowAlways int
+	var numAllow, numDeny, numAllowAlways, numDenyAlways int
 	for _, pol := range All() {
 		switch pol {
 		case Allow():
@@ -79,9 +80,11 @@ func TestPoliciesContents(t *testing.T) {
 			numDeny += 1
 		case AllowAlways():
 			numAllowAlways += 1
+		case DenyAlways():
+			numDenyAlways += 1
 		}
 	}
-	if numAllow != 1 || numDeny != 1 || numAllowAlways != 1 {
+	if numAllow != 1 || numDeny != 1 || numAllowAlways != 1 || numDenyAlways != 1 {
 		t.Error("All() does not contain the 3 base policies exactly once.")
 	}
 }
@@ -103,10 +106,35 @@ func TestUniqueIDS(t *testing.T) {
 
 // Tests that the Policy interface getters match the actual values defined in policy.
 func TestPolicyGetMethods(t *testing.T) {
-	policies := map[Policy]policy{Allow(): allow, Deny(): deny, AllowAlways(): allowAlways}
+	policies := map[Policy]policy{Allow(): allow, Deny(): deny, AllowAlways(): allowAlways, AllowSimilar(): allowSimilar,
+		AllowFor15Minutes(): allowFor15Minutes, AllowUntilRestart(): allowUntilRestart, DenyAlways(): denyAlways}
 	for P, p := range policies {
-		if P.Name() != p.name || P.Shortcut() != p.shortcut || P.Id() != p.id || P.Description() != p.description {
+		if P.Name() != p.name || P.Shortcut() != p.shortcut || P.Id() != p.id || P.Description() != p.description || P.Allows() != p.allows ||
+			P.Duration() != p.duration || P.UntilRestart() != p.untilRestart {
 			t.Error("Policy interface get method does not match policy value")
 		}
 	}
 }
+
+// Tests that only the allow policies allow an action to execute.
+func TestAllows(t *testing.T) {
+	if !Allow().Allows() || !AllowAlways().Allows() || !AllowSimilar().Allows() || !AllowFor15Minutes().Allows() || !AllowUntilRestart().Allows() {
+		t.Error("allow policies should allow the action")
+	}
+	if Deny().Allows() || DenyAlways().Allows() {
+		t.Error("deny policies should not allow the action")
+	}
+}
+
+// Tests that only the time bound policies expire.
+func TestExpiry(t *testing.T) {
+	if AllowFor15Minutes().Duration() != 15*time.Minute || AllowFor15Minutes().UntilRestart() {
+		t.Error("allow for 15 minutes should expire after 15 minutes")
+	}
+	if AllowUntilRestart().Duration() != 0 || !AllowUntilRestart().UntilRestart() {
+		t.Error("allow until restart should only expire on restart")
+	}
+	if AllowAlways().Duration() != 0 || AllowAlways().UntilRestart() {
+		t.Error("allow always should never expire")
+	}
+}
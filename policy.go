@@ -0,0 +1,364 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/config"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"github.com/tcorp-bv/backstage-hook/storage"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// The number of hexadecimal characters of a policy id
+	shortIdLength = 12
+	// The format of times in the output of the policy commands
+	timeFormat = "2006-01-02 15:04"
+)
+
+// Manages policies: backstage-hook policy list|show|revoke|export|import|check
+var policyCommand = &cli.Command{
+	Name:     "policy",
+	Usage:    "Manage the decisions on actions",
+	Commands: []*cli.Command{policyListCommand, policyShowCommand, policyRevokeCommand, policyExportCommand, policyImportCommand, policyCheckCommand},
+}
+
+// Lists the stored policies and rules.
+var policyListCommand = &cli.Command{
+	Name:  "list",
+	Usage: "List the stored decisions and rules",
+	Flags: config.CliFlags("storage"),
+	Handler: func(c *cli.Context) error {
+		store, err := loadStore(c)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\n", cli.YellowColor.Format("POLICIES"))
+		fmt.Fprintf(w, "ID\tPOLICY\tACTION\tSET\tEXPIRES\n")
+		for _, e := range store.Policies() {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", shortId(e.Key), e.Policy.Name(), describeAction(e.Action), e.Set.Format(timeFormat), expiry(e))
+		}
+		fmt.Fprintf(w, "\n%s\n", cli.YellowColor.Format("RULES"))
+		fmt.Fprintf(w, "ID\tPRIORITY\tRULE\n")
+		for _, r := range store.Rules() {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", r.Id, r.Priority, r)
+		}
+		return w.Flush()
+	},
+}
+
+// Shows the details of a stored policy or rule.
+var policyShowCommand = &cli.Command{
+	Name:     "show",
+	Usage:    "Show the details of a stored decision or rule",
+	Flags:    config.CliFlags("storage"),
+	Args:     []cli.Arg{{Name: "id"}},
+	Complete: completeDecisionIds,
+	Handler: func(c *cli.Context) error {
+		store, err := loadStore(c)
+		if err != nil {
+			return err
+		}
+		entry, rule, err := findDecision(store, c.Arg("id"))
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+		if rule != nil {
+			fmt.Fprintf(w, "Rule:\t%s\n", rule.Id)
+			fmt.Fprintf(w, "Decision:\t%s\n", rule.Decision)
+			fmt.Fprintf(w, "Priority:\t%d\n", rule.Priority)
+			plugin := "any plugin"
+			if rule.Plugin != "" {
+				plugin = fmt.Sprintf("%q", rule.Plugin)
+			}
+			fmt.Fprintf(w, "Plugin:\t%s\n", plugin)
+			fmt.Fprintf(w, "Command:\t%q\n", rule.Command)
+			for i, arg := range rule.Args {
+				fmt.Fprintf(w, "Argument %d:\t%q\n", i+1, arg)
+			}
+			for _, dir := range rule.Dirs {
+				fmt.Fprintf(w, "Directory:\t%q\n", dir)
+			}
+			names := make([]string, 0, len(rule.Env))
+			for name := range rule.Env {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(w, "Environment %s:\t%q\n", name, rule.Env[name])
+			}
+			if rule.Stdin != "" {
+				fmt.Fprintf(w, "Input:\t%q\n", rule.Stdin)
+			}
+			if len(rule.PassEnv) > 0 {
+				fmt.Fprintf(w, "Passes:\t%s\n", strings.Join(rule.PassEnv, ", "))
+			}
+			if rule.Executor != "" {
+				fmt.Fprintf(w, "Executor:\t%s\n", rule.Executor)
+			}
+			for _, dir := range rule.Writable {
+				fmt.Fprintf(w, "Writable:\t%q\n", dir)
+			}
+			if rule.Limits != nil {
+				fmt.Fprintf(w, "Limits:\t%s\n", rule.Limits)
+			}
+			return w.Flush()
+		}
+		fmt.Fprintf(w, "Id:\t%s\n", shortId(entry.Key))
+		fmt.Fprintf(w, "Policy:\t%s (%s)\n", entry.Policy.Name(), entry.Policy.Id())
+		if entry.Action == nil {
+			fmt.Fprintf(w, "Action:\t%s\n", describeAction(nil))
+		} else {
+			fmt.Fprintf(w, "Plugin:\t%q\n", entry.Action.Plugin)
+			fmt.Fprintf(w, "Command:\t%q\n", entry.Action.Command.Name)
+			for i, arg := range entry.Action.Command.Args {
+				fmt.Fprintf(w, "Argument %d:\t%q\n", i+1, arg)
+			}
+			if dir := entry.Action.Command.Dir; dir != "" {
+				fmt.Fprintf(w, "Directory:\t%q\n", dir)
+			}
+			for _, env := range entry.Action.Command.Environ() {
+				fmt.Fprintf(w, "Environment:\t%q\n", env)
+			}
+			if stdin := entry.Action.Command.Stdin; stdin != "" {
+				fmt.Fprintf(w, "Input:\t%q\n", stdin)
+			}
+			if exe := entry.Action.Executable; exe != nil {
+				fmt.Fprintf(w, "Executable:\t%q\n", exe.Path)
+				fmt.Fprintf(w, "SHA-256:\t%s\n", exe.Digest)
+			}
+		}
+		fmt.Fprintf(w, "Set:\t%s\n", entry.Set.Format(timeFormat))
+		fmt.Fprintf(w, "Expires:\t%s\n", expiry(*entry))
+		return w.Flush()
+	},
+}
+
+// Revokes a stored policy or rule, the user is asked again for the actions it decided on.
+var policyRevokeCommand = &cli.Command{
+	Name:     "revoke",
+	Usage:    "Revoke a stored decision or rule, you are asked again for the actions it decided on",
+	Flags:    config.CliFlags("storage"),
+	Args:     []cli.Arg{{Name: "id"}},
+	Complete: completeDecisionIds,
+	Handler: func(c *cli.Context) error {
+		store, err := loadStore(c)
+		if err != nil {
+			return err
+		}
+		entry, rule, err := findDecision(store, c.Arg("id"))
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			store.RemoveRule(rule.Id)
+			fmt.Fprintf(c.App.Writer, "Revoked rule %s: %s\n", rule.Id, rule)
+			return nil
+		}
+		store.RevokePolicy(entry.Key)
+		fmt.Fprintf(c.App.Writer, "Revoked %s for %s\n", entry.Policy.Name(), describeAction(entry.Action))
+		return nil
+	},
+}
+
+// Exports the stored policies and rules as JSON.
+var policyExportCommand = &cli.Command{
+	Name:  "export",
+	Usage: "Export the decisions that do not expire and the rules as JSON (default: to the standard output)",
+	Flags: config.CliFlags("storage"),
+	Args:  []cli.Arg{{Name: "file", Optional: true}},
+	Handler: func(c *cli.Context) error {
+		store, err := loadStore(c)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(storage.ExportPolicies(store), "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		file := c.Arg("file")
+		if file == "" {
+			_, err = c.App.Writer.Write(data)
+			return err
+		}
+		return ioutil.WriteFile(file, data, 0600)
+	},
+}
+
+// Imports policies and rules that were exported on another machine.
+var policyImportCommand = &cli.Command{
+	Name:  "import",
+	Usage: "Import exported decisions and rules, replacing the decisions for the same actions",
+	Flags: config.CliFlags("storage"),
+	Args:  []cli.Arg{{Name: "file"}},
+	Handler: func(c *cli.Context) error {
+		store, err := loadStore(c)
+		if err != nil {
+			return err
+		}
+		file := c.Arg("file")
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var e storage.Export
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if err := storage.ImportPolicies(store, e); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		fmt.Fprintf(c.App.Writer, "Imported %d decisions and %d rules\n", len(e.Policies), len(e.Rules))
+		return nil
+	},
+}
+
+// Lints a policy file without starting the hook.
+var policyCheckCommand = &cli.Command{
+	Name:  "check",
+	Usage: "Check that a policy file is valid, every invalid rule is reported with its line",
+	Args:  []cli.Arg{{Name: "file"}},
+	Handler: func(c *cli.Context) error {
+		file := c.Arg("file")
+		rs, err := readPolicyFile(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "%s is valid: %d rules\n", file, len(rs))
+		return nil
+	},
+}
+
+// Reads the rules in the policy file at path. Every invalid rule is reported as path:line: error.
+func readPolicyFile(path string) ([]rules.Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := rules.ParseFile(data)
+	if errs, ok := err.(rules.FileErrors); ok {
+		lines := make([]string, len(errs))
+		for i, e := range errs {
+			lines[i] = fmt.Sprintf("%s:%d: %v", path, e.Line, e.Err)
+		}
+		return nil, errors.New(strings.Join(lines, "\n"))
+	}
+	return rs, err
+}
+
+// Loads the rules in the policy file at path into the store, replacing the rules that the policy file loaded before.
+func loadPolicyFile(store storage.Store, path string) error {
+	rs, err := readPolicyFile(path)
+	if err != nil {
+		return err
+	}
+	return store.ReplaceRules(rules.FilePrefix, rs)
+}
+
+// Completes the id of a stored policy or rule, in the storage of the -storage flag.
+func completeDecisionIds(c *cli.Context) []string {
+	if len(c.Args) > 0 {
+		return nil
+	}
+	store, err := loadStore(c)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, e := range store.Policies() {
+		ids = append(ids, fmt.Sprintf("%s\t%s %s", shortId(e.Key), e.Policy.Name(), describeAction(e.Action)))
+	}
+	for _, r := range store.Rules() {
+		ids = append(ids, fmt.Sprintf("%s\t%s", r.Id, r))
+	}
+	return ids
+}
+
+// Finds the rule with id, or the stored policy whose id (or key) starts with id. Exactly one of both is returned if
+// there is no error.
+func findDecision(store storage.Store, id string) (*storage.PolicyEntry, *rules.Rule, error) {
+	for _, r := range store.Rules() {
+		if r.Id == id {
+			return nil, &r, nil
+		}
+	}
+	var found []storage.PolicyEntry
+	for _, e := range store.Policies() {
+		if e.Key == id || strings.HasPrefix(shortId(e.Key), id) {
+			found = append(found, e)
+		}
+	}
+	switch {
+	case id == "" || len(found) == 0:
+		return nil, nil, fmt.Errorf("there is no decision or rule with id %q, see policy list", id)
+	case len(found) > 1:
+		return nil, nil, fmt.Errorf("id %q matches %d decisions, use more characters of the id", id, len(found))
+	}
+	return &found[0], nil, nil
+}
+
+// Returns the id of a stored policy as shown in the policy list. Keys are long and may share a prefix, so the id is
+// derived from a hash of the key.
+func shortId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:shortIdLength]
+}
+
+// Describes an action on a single line, eg. "git status" by "my-plugin".
+func describeAction(a *actions.Action) string {
+	if a == nil {
+		return "unknown action"
+	}
+	return fmt.Sprintf("%.60q by %q", a.Command.String(), a.Plugin)
+}
+
+// Describes when a stored policy expires.
+func expiry(e storage.PolicyEntry) string {
+	switch {
+	case !e.Expires.IsZero():
+		return e.Expires.Format(timeFormat)
+	case e.Policy.UntilRestart():
+		return "on restart"
+	}
+	return "never"
+}
@@ -0,0 +1,205 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prefix of the ids of rules that were loaded from a policy file, they are replaced when the file is loaded again.
+const FilePrefix = "file:"
+
+// Returned by the parser when the rest of the file can not be read, the cause was already recorded.
+var errStopParsing = errors.New("stop parsing")
+
+// An invalid entry in a policy file.
+type FileError struct {
+	// The line of the entry, starting at 1
+	Line int
+	// What is wrong with the entry
+	Err error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// All invalid entries in a policy file.
+type FileErrors []*FileError
+
+func (e FileErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Parses a policy file, a JSON object with a list of rules, eg.
+//
+//	{"rules": [{"plugin": "my-plugin", "command": "git", "args": ["status", "**"], "decision": "allow"}]}
+//
+// The id of a rule is optional, every id is prefixed with FilePrefix. If the file is invalid, a FileErrors is returned
+// that contains every invalid rule with its line.
+func ParseFile(data []byte) ([]Rule, error) {
+	p := &fileParser{data: data, dec: json.NewDecoder(bytes.NewReader(data)), ids: map[string]int{}}
+	if err := p.parse(); err != nil && err != errStopParsing {
+		p.fail(p.dec.InputOffset(), err)
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	return p.rules, nil
+}
+
+// Parses a policy file and remembers every invalid entry.
+type fileParser struct {
+	data  []byte
+	dec   *json.Decoder
+	rules []Rule
+	errs  FileErrors
+	// The line of every rule by id, to report duplicates
+	ids map[string]int
+}
+
+// Parses the top level object, errors that make the rest of the file unreadable are returned.
+func (p *fileParser) parse() error {
+	if err := p.delim('{'); err != nil {
+		return err
+	}
+	for p.dec.More() {
+		offset := p.dec.InputOffset()
+		key, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		if key != "rules" {
+			p.fail(offset, fmt.Errorf("unknown field %q, expected \"rules\"", key))
+			var skip json.RawMessage
+			if err := p.dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.parseRules(); err != nil {
+			return err
+		}
+	}
+	if err := p.delim('}'); err != nil {
+		return err
+	}
+	if _, err := p.dec.Token(); err != io.EOF {
+		return errors.New("unexpected content after the policy")
+	}
+	return nil
+}
+
+// Parses the list of rules.
+func (p *fileParser) parseRules() error {
+	if err := p.delim('['); err != nil {
+		return err
+	}
+	for p.dec.More() {
+		start := p.valueStart(p.dec.InputOffset())
+		var raw json.RawMessage
+		if err := p.dec.Decode(&raw); err != nil { // The rest of the file can not be read
+			return err
+		}
+		r, err := decodeRule(raw)
+		if err != nil {
+			if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+				start += typeErr.Offset
+			}
+			p.fail(start, err)
+			continue
+		}
+		if r.Id == "" {
+			r.Id = generateId(r)
+		}
+		r.Id = FilePrefix + r.Id
+		if err := r.Validate(); err != nil {
+			p.fail(start, err)
+			continue
+		}
+		if line, ok := p.ids[r.Id]; ok {
+			p.fail(start, fmt.Errorf("duplicate id %q, also used on line %d", strings.TrimPrefix(r.Id, FilePrefix), line))
+			continue
+		}
+		p.ids[r.Id] = p.line(start)
+		p.rules = append(p.rules, r)
+	}
+	return p.delim(']')
+}
+
+// Decodes a single rule, fields that are not part of a rule are rejected.
+func decodeRule(raw json.RawMessage) (Rule, error) {
+	var r Rule
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return r, dec.Decode(&r)
+}
+
+// Reads the next token and checks that it is the delimiter d.
+func (p *fileParser) delim(d json.Delim) error {
+	offset := p.dec.InputOffset()
+	t, err := p.dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		p.fail(offset, fmt.Errorf("expected %q", d))
+		return errStopParsing
+	}
+	return nil
+}
+
+// Returns the offset of the first character of the value after offset, skipping whitespace and separators.
+func (p *fileParser) valueStart(offset int64) int64 {
+	for offset < int64(len(p.data)) && strings.IndexByte(" \t\r\n,:", p.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// Returns the line of the character at offset.
+func (p *fileParser) line(offset int64) int {
+	if offset > int64(len(p.data)) {
+		offset = int64(len(p.data))
+	}
+	return bytes.Count(p.data[:offset], []byte("\n")) + 1
+}
+
+// Remembers that the entry at offset is invalid.
+func (p *fileParser) fail(offset int64, err error) {
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		offset = syntaxErr.Offset
+	}
+	p.errs = append(p.errs, &FileError{Line: p.line(p.valueStart(offset)), Err: err})
+}
@@ -0,0 +1,96 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rules
+
+import (
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"testing"
+)
+
+// Makes sure that a valid policy file is parsed into rules with prefixed ids.
+func TestParseFile(t *testing.T) {
+	rs, err := ParseFile([]byte(`{
+	"rules": [
+		{"id": "status", "plugin": "my-plugin", "command": "git", "args": ["status", "**"], "decision": "allow"},
+		{"priority": 10, "command": "rm", "args": ["**"], "decision": "deny"}
+	]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].Id != FilePrefix+"status" || rs[1].Priority != 10 || len(rs[1].Id) <= len(FilePrefix) {
+		t.Fatal("unexpected rules: ", rs)
+	}
+	status := actions.Action{Plugin: "my-plugin", Command: actions.Command{Name: "git", Args: []string{"status", "-s"}}}
+	if r, ok := Match(rs, status); !ok || r.Decision != Allow {
+		t.Error("the parsed rule does not match")
+	}
+}
+
+// Makes sure that every invalid rule is reported with its line.
+func TestParseFileErrors(t *testing.T) {
+	_, err := ParseFile([]byte(`{
+	"rules": [
+		{"command": "git", "decision": "allow"},
+		{"command": "git", "decision": "maybe"},
+		{"command": "git", "decision": "allow", "unknown": 1},
+		{"command": 1, "decision": "allow"},
+		{"id": "a", "command": "ls", "decision": "allow"},
+		{"id": "a", "command": "ls", "decision": "deny"},
+		{"command": "re:(", "decision": "deny"}
+	]
+}`))
+	errs, ok := err.(FileErrors)
+	if !ok {
+		t.Fatal("expected FileErrors, got ", err)
+	}
+	lines := []int{4, 5, 6, 8, 9}
+	if len(errs) != len(lines) {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	for i, line := range lines {
+		if errs[i].Line != line {
+			t.Error("expected error ", i, " on line ", line, ", got ", errs[i])
+		}
+	}
+}
+
+// Makes sure that syntax errors and unexpected structures are reported with their line.
+func TestParseFileSyntax(t *testing.T) {
+	cases := map[string]int{
+		"{\n\"rules\": [\n{\"command\": \"ls\",,}\n]}": 3,
+		"[]":                          1,
+		"{\"rules\": []}\n{}":         2,
+		"{\n\"rule\": []\n}":          2,
+		"{\"rules\": [\n{\"command\"": 2,
+	}
+	for data, line := range cases {
+		_, err := ParseFile([]byte(data))
+		errs, ok := err.(FileErrors)
+		if !ok || len(errs) == 0 || errs[0].Line != line {
+			t.Errorf("expected an error on line %d for %q, got %v", line, data, err)
+		}
+	}
+}
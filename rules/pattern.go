@@ -0,0 +1,100 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rules
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// Argument pattern that matches any remaining arguments, including none
+	AnyArgs Pattern = "**"
+	// Prefix of patterns that are regular expressions instead of globs
+	regexPrefix = "re:"
+)
+
+// A pattern matches a single value (eg. an argument) of an action. By default a pattern is a glob where * matches any
+// sequence of characters, ? matches a single character and \ escapes the next character. Patterns starting with "re:"
+// are regular expressions, which have to match the complete value.
+type Pattern string
+
+// Creates a pattern that only matches value.
+func Literal(value string) Pattern {
+	var b strings.Builder
+	if strings.HasPrefix(value, regexPrefix) { // Escape the r so the value is not read as a regular expression
+		b.WriteRune('\\')
+	}
+	for _, r := range value {
+		if r == '*' || r == '?' || r == '\\' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return Pattern(b.String())
+}
+
+// Whether value matches the pattern. Invalid patterns never match.
+func (p Pattern) Matches(value string) bool {
+	re, err := p.compile()
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// Returns an error if the pattern is not a valid regular expression.
+func (p Pattern) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+// Compiles the pattern into an anchored regular expression.
+func (p Pattern) compile() (*regexp.Regexp, error) {
+	if strings.HasPrefix(string(p), regexPrefix) {
+		return regexp.Compile("^(?:" + strings.TrimPrefix(string(p), regexPrefix) + ")$")
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range string(p) {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped { // A trailing backslash matches itself
+		b.WriteString(`\\`)
+	}
+	return regexp.Compile("^(?s:" + b.String() + ")$")
+}
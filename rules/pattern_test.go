@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rules
+
+import "testing"
+
+func TestPatternMatches(t *testing.T) {
+	cases := []struct {
+		pattern Pattern
+		value   string
+		matches bool
+	}{
+		{"status", "status", true},
+		{"status", "statuses", false},
+		{"*", "", true},
+		{"*", "any/path with spaces", true},
+		{"-?", "-s", true},
+		{"-?", "-sb", false},
+		{"*.yaml", "deploy/app.yaml", true},
+		{"*.yaml", "app.yml", false},
+		{`\*`, "*", true},
+		{`\*`, "anything", false},
+		{"re:-[a-z]+", "-abc", true},
+		{"re:-[a-z]+", "x-abc", false},
+		{"re:-[a-z]+", "-abc1", false},
+		{"re:(", "(", false}, // Invalid patterns never match
+	}
+	for _, c := range cases {
+		if c.pattern.Matches(c.value) != c.matches {
+			t.Errorf("pattern %q matching %q should be %v", c.pattern, c.value, c.matches)
+		}
+	}
+}
+
+// Makes sure that a literal only matches its own value, even if it contains pattern syntax.
+func TestLiteral(t *testing.T) {
+	values := []string{"", "status", "*", "?", `\`, `a\*b`, "**", "re:.*", "{\"name\":\"test\"}"}
+	for _, v := range values {
+		for _, other := range values {
+			if Literal(v).Matches(other) != (v == other) {
+				t.Errorf("literal %q matching %q should be %v", Literal(v), other, v == other)
+			}
+		}
+	}
+}
+
+func TestPatternValidate(t *testing.T) {
+	if Pattern("re:(").Validate() == nil {
+		t.Error("invalid regular expression should not be valid")
+	}
+	if Pattern("*.go").Validate() != nil || Pattern(`trailing\`).Validate() != nil {
+		t.Error("glob patterns should be valid")
+	}
+}
@@ -0,0 +1,375 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The outcome of a rule that matches an action.
+type Decision string
+
+const (
+	// Allow the matching actions without asking the user
+	Allow Decision = "allow"
+	// Deny the matching actions without asking the user
+	Deny Decision = "deny"
+)
+
+// Returns the policy that is applied to actions that match a rule with this decision, nil if the decision is invalid.
+func (d Decision) Policy() policies.Policy {
+	switch d {
+	case Allow:
+		return policies.AllowSimilar()
+	case Deny:
+		return policies.Deny()
+	}
+	return nil
+}
+
+// A rule decides on all actions that match its patterns, unlike a stored policy which only decides on one exact action.
+type Rule struct {
+	// Unique identifier of the rule, a rule with the same Id replaces an existing rule
+	Id string `json:"id"`
+	// Rules with a higher priority are evaluated first
+	Priority int `json:"priority,omitempty"`
+	// The plugin that requests the action, an empty pattern matches any plugin
+	Plugin Pattern `json:"plugin,omitempty"`
+	// The name of the command
+	Command Pattern `json:"command"`
+	// One pattern per argument. The last pattern may be AnyArgs to match any remaining arguments.
+	Args []Pattern `json:"args,omitempty"`
+	// The directories in which (or below which) the action must run, a leading ~ is the home directory of the user.
+	// Actions with a directory only match rules with directories and the other way around.
+	Dirs []string `json:"dirs,omitempty"`
+	// One pattern per environment variable that the action may set, by name. Actions that set other variables do not
+	// match, the action does not have to set all of them.
+	Env map[string]Pattern `json:"env,omitempty"`
+	// The standard input of the action, an empty pattern only matches actions without input
+	Stdin Pattern `json:"stdin,omitempty"`
+	// The executable that the command must resolve to (path and digest), any executable if nil. Generated rules are
+	// pinned to the executable that was approved, see FromAction.
+	Executable *actions.Executable `json:"executable,omitempty"`
+	// The names of variables in the environment of the hook that are passed to the matching actions, in addition to the
+	// variables that are passed to every action. Only rules that allow can pass variables.
+	PassEnv []string `json:"passEnv,omitempty"`
+	// The executor that runs the matching actions (eg. sandbox), the default executor of the hook if empty. Only rules
+	// that allow can choose an executor.
+	Executor string `json:"executor,omitempty"`
+	// The directories the matching actions may write to when they run in the sandbox, a leading ~ is the home directory
+	// of the user
+	Writable []string `json:"writable,omitempty"`
+	// The resource limits of the matching actions, in addition to the limits of the hook. Only rules that allow can set
+	// limits.
+	Limits *executor.Limits `json:"limits,omitempty"`
+	// The decision for matching actions
+	Decision Decision `json:"decision"`
+}
+
+// Checks whether the rule is complete and all of its patterns are valid.
+func (r Rule) Validate() error {
+	if r.Id == "" {
+		return errors.New("rule has no id")
+	}
+	if r.Decision.Policy() == nil {
+		return fmt.Errorf("unknown decision %q, expected %q or %q", r.Decision, Allow, Deny)
+	}
+	if r.Command == "" {
+		return errors.New("rule has no command pattern")
+	}
+	if err := r.Plugin.Validate(); err != nil {
+		return fmt.Errorf("plugin: %v", err)
+	}
+	if err := r.Command.Validate(); err != nil {
+		return fmt.Errorf("command: %v", err)
+	}
+	for i, arg := range r.Args {
+		if arg == AnyArgs && i != len(r.Args)-1 {
+			return fmt.Errorf("argument %d: %s may only be used as the last argument", i+1, AnyArgs)
+		}
+		if err := arg.Validate(); err != nil {
+			return fmt.Errorf("argument %d: %v", i+1, err)
+		}
+	}
+	for _, dir := range r.Dirs {
+		if _, err := expandHome(dir); err != nil {
+			return fmt.Errorf("directory %q: %v", dir, err)
+		}
+	}
+	for name, pattern := range r.Env {
+		if err := pattern.Validate(); err != nil {
+			return fmt.Errorf("environment variable %s: %v", name, err)
+		}
+	}
+	if err := r.Stdin.Validate(); err != nil {
+		return fmt.Errorf("stdin: %v", err)
+	}
+	for _, name := range r.PassEnv {
+		if !actions.ValidEnvName(name) {
+			return fmt.Errorf("passEnv: invalid environment variable name %q", name)
+		}
+	}
+	if len(r.PassEnv) > 0 && r.Decision != Allow {
+		return errors.New("passEnv: only rules that allow can pass environment variables")
+	}
+	if r.Executor != "" && !executor.Known(r.Executor) {
+		return fmt.Errorf("unknown executor %q, expected %q or %q", r.Executor, executor.Local, executor.Sandbox)
+	}
+	if r.Executor != "" && r.Decision != Allow {
+		return errors.New("executor: only rules that allow can choose an executor")
+	}
+	for _, dir := range r.Writable {
+		if _, err := expandHome(dir); err != nil {
+			return fmt.Errorf("writable directory %q: %v", dir, err)
+		}
+	}
+	if len(r.Writable) > 0 && r.Executor != executor.Sandbox {
+		return fmt.Errorf("writable: only actions that run in the %s can be restricted to writable directories", executor.Sandbox)
+	}
+	if r.Limits != nil && r.Decision != Allow {
+		return errors.New("limits: only rules that allow can set limits")
+	}
+	return nil
+}
+
+// Whether the action matches all patterns of this rule.
+func (r Rule) Matches(a actions.Action) bool {
+	if r.Plugin != "" && !r.Plugin.Matches(a.Plugin) {
+		return false
+	}
+	if !r.Command.Matches(a.Command.Name) {
+		return false
+	}
+	if r.Executable != nil && !r.Executable.Equal(a.Executable) {
+		return false
+	}
+	if !r.matchesContext(a.Command) {
+		return false
+	}
+	args := a.Command.Args
+	for i, pattern := range r.Args {
+		if pattern == AnyArgs {
+			return true
+		}
+		if i >= len(args) || !pattern.Matches(args[i]) {
+			return false
+		}
+	}
+	return len(args) == len(r.Args)
+}
+
+// Whether the directory, environment and standard input of the command are allowed by the rule.
+func (r Rule) matchesContext(c actions.Command) bool {
+	if (c.Dir == "") != (len(r.Dirs) == 0) {
+		return false
+	}
+	if c.Dir != "" && !r.matchesDir(c.Dir) {
+		return false
+	}
+	for name, value := range c.Env {
+		pattern, ok := r.Env[name]
+		if !ok || !pattern.Matches(value) {
+			return false
+		}
+	}
+	if r.Stdin == "" {
+		return c.Stdin == ""
+	}
+	return r.Stdin.Matches(c.Stdin)
+}
+
+// Whether dir is one of the directories of the rule or below one of them. Symbolic links are resolved first, so a link
+// can not lead outside of the directories.
+func (r Rule) matchesDir(dir string) bool {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range r.Dirs {
+		allowed, err := expandHome(allowed)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(allowed); err == nil {
+			allowed = resolved
+		}
+		if rel, err := filepath.Rel(allowed, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the writable directories of the rule as absolute paths.
+func (r Rule) WritableDirs() []string {
+	var dirs []string
+	for _, dir := range r.Writable {
+		if dir, err := expandHome(dir); err == nil { // Invalid directories are rejected by Validate
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Replaces a leading ~ in dir by the home directory of the user, the result must be an absolute path.
+func expandHome(dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, dir[1:])
+	}
+	if !filepath.IsAbs(dir) {
+		return "", errors.New("not an absolute path")
+	}
+	return filepath.Clean(dir), nil
+}
+
+// Human readable representation of the rule, eg. allow "git status **" by "my-plugin".
+func (r Rule) String() string {
+	patterns := []string{string(r.Command)}
+	for _, arg := range r.Args {
+		patterns = append(patterns, string(arg))
+	}
+	plugin := "any plugin"
+	if r.Plugin != "" {
+		plugin = fmt.Sprintf("%q", r.Plugin)
+	}
+	s := fmt.Sprintf("%s %q by %s", r.Decision, strings.Join(patterns, " "), plugin)
+	if r.Executable != nil {
+		s += fmt.Sprintf(" running %q", r.Executable.Path)
+	}
+	if len(r.Dirs) > 0 {
+		dirs := make([]string, len(r.Dirs))
+		for i, dir := range r.Dirs {
+			dirs[i] = fmt.Sprintf("%q", dir)
+		}
+		s += " in " + strings.Join(dirs, ", ")
+	}
+	if len(r.Env) > 0 {
+		names := make([]string, 0, len(r.Env))
+		for name := range r.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s += " with " + strings.Join(names, ", ")
+	}
+	if r.Stdin != "" {
+		s += fmt.Sprintf(" and input %q", r.Stdin)
+	}
+	if len(r.PassEnv) > 0 {
+		s += ", passing " + strings.Join(r.PassEnv, ", ")
+	}
+	if r.Executor != "" {
+		s += " using the " + r.Executor
+	}
+	if r.Limits != nil && *r.Limits != (executor.Limits{}) {
+		s += " limited to " + r.Limits.String()
+	}
+	return s
+}
+
+// Generates a rule that matches the action and similar actions: the same plugin and command with the same leading flags
+// and first argument (eg. "-C /src status" in "git -C /src status -s") followed by any arguments. Leading flags change
+// what the command does (eg. "sh -c"), so they and the argument that may be their value are kept. An action without
+// arguments only matches the same command without arguments. The environment variables and the input must be exactly
+// the same, a variable like GIT_SSH_COMMAND or the input of "sh -s" can run anything. The directory must be the same or
+// below it, and the command must resolve to the same executable.
+func FromAction(a actions.Action, d Decision) Rule {
+	r := Rule{Plugin: Literal(a.Plugin), Command: Literal(a.Command.Name), Decision: d}
+	if a.Executable != nil {
+		exe := *a.Executable
+		r.Executable = &exe
+	}
+	args := a.Command.Args
+	n := 0
+	for n < len(args) && strings.HasPrefix(args[n], "-") {
+		n++
+		if n < len(args) && !strings.Contains(args[n-1], "=") { // The next argument may be the value of the flag
+			n++
+		}
+	}
+	if n < len(args) {
+		n++ // Eg. a subcommand like "status"
+	}
+	for _, arg := range args[:n] {
+		r.Args = append(r.Args, Literal(arg))
+	}
+	if len(args) > 0 {
+		r.Args = append(r.Args, AnyArgs)
+	}
+	if a.Command.Dir != "" {
+		r.Dirs = []string{a.Command.Dir}
+	}
+	for name := range a.Command.Env {
+		if r.Env == nil {
+			r.Env = map[string]Pattern{}
+		}
+		r.Env[name] = Literal(a.Command.Env[name])
+	}
+	if a.Command.Stdin != "" {
+		r.Stdin = Literal(a.Command.Stdin)
+	}
+	r.Id = generateId(r)
+	return r
+}
+
+// Generates an id from the contents of the rule, so that generating the same rule twice does not add a duplicate rule.
+func generateId(r Rule) string {
+	bytes, err := json.Marshal(r)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(bytes)
+	return "rule-" + hex.EncodeToString(sum[:6])
+}
+
+// Returns the rule with the highest priority that matches the action. If multiple rules with the same priority match,
+// the first one in rs is returned. The second return value is false if no rule matches.
+func Match(rs []Rule, a actions.Action) (Rule, bool) {
+	sorted := make([]Rule, len(rs))
+	copy(sorted, rs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	for _, r := range sorted {
+		if r.Matches(a) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
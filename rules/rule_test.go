@@ -0,0 +1,234 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rules
+
+import (
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func action(plugin string, name string, args ...string) actions.Action {
+	return actions.Action{Plugin: plugin, Command: actions.Command{Name: name, Args: args}}
+}
+
+func TestRuleMatches(t *testing.T) {
+	r := Rule{Id: "git", Plugin: "git-*", Command: "git", Args: []Pattern{"status", AnyArgs}, Decision: Allow}
+	cases := []struct {
+		action  actions.Action
+		matches bool
+	}{
+		{action("git-plugin", "git", "status"), true},
+		{action("git-plugin", "git", "status", "-s"), true},
+		{action("git-plugin", "git"), false},
+		{action("git-plugin", "git", "push"), false},
+		{action("other-plugin", "git", "status"), false},
+		{action("git-plugin", "rm", "status"), false},
+	}
+	for _, c := range cases {
+		if r.Matches(c.action) != c.matches {
+			t.Errorf("rule matching %v should be %v", c.action, c.matches)
+		}
+	}
+
+	exact := Rule{Id: "ls", Command: "ls", Args: []Pattern{"-l"}, Decision: Allow}
+	if !exact.Matches(action("any", "ls", "-l")) || exact.Matches(action("any", "ls", "-l", "/")) || exact.Matches(action("any", "ls")) {
+		t.Error("rule without AnyArgs should match the exact number of arguments")
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	valid := Rule{Id: "id", Command: "git", Args: []Pattern{"re:[a-z]+", AnyArgs}, Decision: Deny}
+	if err := valid.Validate(); err != nil {
+		t.Error("valid rule is invalid: ", err)
+	}
+	passing := Rule{Id: "id", Command: "gh", PassEnv: []string{"GITHUB_TOKEN"}, Executor: "sandbox", Writable: []string{"~/src"}, Limits: &executor.Limits{CPU: 60}, Decision: Allow}
+	if err := passing.Validate(); err != nil {
+		t.Error("valid rule is invalid: ", err)
+	}
+	if home, err := os.UserHomeDir(); err == nil && (len(passing.WritableDirs()) != 1 || passing.WritableDirs()[0] != filepath.Join(home, "src")) {
+		t.Error("writable directories were not expanded: ", passing.WritableDirs())
+	}
+	invalid := []Rule{
+		{Command: "git", Decision: Allow},
+		{Id: "id", Decision: Allow},
+		{Id: "id", Command: "git", Decision: "maybe"},
+		{Id: "id", Command: "re:(", Decision: Allow},
+		{Id: "id", Command: "git", Args: []Pattern{AnyArgs, "status"}, Decision: Allow},
+		{Id: "id", Command: "git", Dirs: []string{"src"}, Decision: Allow},
+		{Id: "id", Command: "git", Env: map[string]Pattern{"GIT_DIR": "re:("}, Decision: Allow},
+		{Id: "id", Command: "git", Stdin: "re:(", Decision: Allow},
+		{Id: "id", Command: "git", PassEnv: []string{"GITHUB-TOKEN"}, Decision: Allow},
+		{Id: "id", Command: "git", PassEnv: []string{"GITHUB_TOKEN"}, Decision: Deny},
+		{Id: "id", Command: "git", Executor: "docker", Decision: Allow},
+		{Id: "id", Command: "git", Executor: "sandbox", Decision: Deny},
+		{Id: "id", Command: "git", Writable: []string{"/src"}, Decision: Allow},
+		{Id: "id", Command: "git", Executor: "sandbox", Writable: []string{"src"}, Decision: Allow},
+		{Id: "id", Command: "git", Limits: &executor.Limits{CPU: 60}, Decision: Deny},
+	}
+	for _, r := range invalid {
+		if r.Validate() == nil {
+			t.Errorf("rule %v should be invalid", r)
+		}
+	}
+}
+
+// Makes sure that a generated rule matches the original action and similar actions, but nothing more.
+func TestFromAction(t *testing.T) {
+	r := FromAction(action("my*plugin", "git", "status"), Allow)
+	if r.Validate() != nil || r.Decision.Policy() != policies.AllowSimilar() {
+		t.Error("generated rule is invalid")
+	}
+	if !r.Matches(action("my*plugin", "git", "status")) || !r.Matches(action("my*plugin", "git", "status", "-s")) {
+		t.Error("generated rule does not match similar actions")
+	}
+	if r.Matches(action("my-other-plugin", "git", "status")) || r.Matches(action("my*plugin", "git", "push")) {
+		t.Error("generated rule matches too many actions")
+	}
+	if r.Id != FromAction(action("my*plugin", "git", "status"), Allow).Id {
+		t.Error("generating the same rule twice should result in the same id")
+	}
+
+	// Leading flags and their values are kept, so the rule does not allow any invocation of the command
+	git := FromAction(action("p", "git", "-C", "/src", "status"), Allow)
+	if !git.Matches(action("p", "git", "-C", "/src", "status", "-s")) {
+		t.Error("generated rule does not match similar actions: ", git)
+	}
+	if git.Matches(action("p", "git", "-C", "/src", "push")) || git.Matches(action("p", "git", "push")) || git.Matches(action("p", "git", "-C", "/other", "status")) {
+		t.Error("generated rule does not keep the leading flags: ", git)
+	}
+	sh := FromAction(action("p", "sh", "-c", "echo hello"), Allow)
+	if !sh.Matches(action("p", "sh", "-c", "echo hello")) || sh.Matches(action("p", "sh", "-c", "rm -rf ~")) || sh.Matches(action("p", "sh", "-e", "-c", "echo hello")) {
+		t.Error("generated rule does not keep the script of sh -c: ", sh)
+	}
+	ls := FromAction(action("p", "ls", "--color=auto", "-la", "src"), Allow)
+	if !ls.Matches(action("p", "ls", "--color=auto", "-la", "src", "more")) || ls.Matches(action("p", "ls", "--color=auto", "-la")) {
+		t.Error("generated rule does not keep the flags and the first argument: ", ls)
+	}
+
+	// The rule is pinned to the executable the action resolved to
+	pinned := action("p", "git", "status")
+	pinned.Executable = &actions.Executable{Path: "/usr/bin/git", Digest: "1234"}
+	exe := FromAction(pinned, Allow)
+	if !exe.Matches(pinned) || !strings.Contains(exe.String(), "/usr/bin/git") {
+		t.Error("generated rule does not match the executable it was generated for: ", exe)
+	}
+	for _, other := range []*actions.Executable{{Path: "/usr/bin/git", Digest: "5678"}, {Path: "/tmp/git", Digest: "1234"}, nil} {
+		pinned.Executable = other
+		if exe.Matches(pinned) {
+			t.Error("generated rule matches another executable: ", other)
+		}
+	}
+
+	// Without arguments, no arguments may be added
+	bare := FromAction(action("p", "sh"), Allow)
+	if bare.Validate() != nil || !bare.Matches(action("p", "sh")) || bare.Matches(action("p", "sh", "-c", "rm -rf ~")) {
+		t.Error("generated rule for an action without arguments matches other arguments: ", bare)
+	}
+}
+
+// Makes sure that the directory, environment and input of an action are constrained by a rule.
+func TestRuleMatchesContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backstage-hook-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, repo, outside := filepath.Join(dir, "src"), filepath.Join(dir, "src", "repo"), filepath.Join(dir, "srcx")
+	for _, d := range []string{repo, outside} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(src, "link")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Rule{Id: "make", Command: "make", Dirs: []string{src}, Env: map[string]Pattern{"TARGET": "re:[a-z]+"}, Stdin: "yes*", Decision: Allow}
+	in := func(dir string, env map[string]string, stdin string) actions.Action {
+		a := action("p", "make")
+		a.Command.Dir, a.Command.Env, a.Command.Stdin = dir, env, stdin
+		return a
+	}
+	cases := []struct {
+		action  actions.Action
+		matches bool
+	}{
+		{in(src, nil, "yes"), true},
+		{in(repo, map[string]string{"TARGET": "all"}, "yes\nyes"), true},
+		{in(outside, nil, "yes"), false},
+		{in(link, nil, "yes"), false},
+		{in("", nil, "yes"), false},
+		{in(repo, map[string]string{"TARGET": "ALL"}, "yes"), false},
+		{in(repo, map[string]string{"PATH": "/tmp"}, "yes"), false},
+		{in(repo, nil, ""), false},
+	}
+	for _, c := range cases {
+		if r.Matches(c.action) != c.matches {
+			t.Errorf("rule matching %v should be %v", c.action.Command, c.matches)
+		}
+	}
+
+	plain := Rule{Id: "make", Command: "make", Decision: Allow}
+	if !plain.Matches(in("", nil, "")) || plain.Matches(in(src, nil, "")) || plain.Matches(in("", map[string]string{"A": "b"}, "")) || plain.Matches(in("", nil, "y")) {
+		t.Error("rule without constraints should only match actions without directory, environment and input")
+	}
+	similar := FromAction(in(src, map[string]string{"TARGET": "all*"}, "y*s"), Allow)
+	if !similar.Matches(in(repo, map[string]string{"TARGET": "all*"}, "y*s")) || similar.Matches(in(outside, map[string]string{"TARGET": "all*"}, "y*s")) {
+		t.Error("generated rule does not constrain the directory like the action")
+	}
+	if similar.Matches(in(repo, map[string]string{"TARGET": "all; rm -rf ~"}, "y*s")) || similar.Matches(in(repo, map[string]string{"TARGET": "all*"}, "yes")) {
+		t.Error("generated rule matches a changed environment variable or input")
+	}
+}
+
+// Makes sure that rules are evaluated in order of priority.
+func TestMatchPriority(t *testing.T) {
+	rs := []Rule{
+		{Id: "allow-git", Command: "git", Args: []Pattern{AnyArgs}, Decision: Allow},
+		{Id: "deny-push", Priority: 10, Command: "git", Args: []Pattern{"push", AnyArgs}, Decision: Deny},
+		{Id: "allow-all", Command: "*", Args: []Pattern{AnyArgs}, Decision: Allow},
+	}
+	cases := map[string]actions.Action{
+		"deny-push": action("p", "git", "push", "origin"),
+		"allow-git": action("p", "git", "status"),
+		"allow-all": action("p", "ls"),
+	}
+	for id, a := range cases {
+		r, ok := Match(rs, a)
+		if !ok || r.Id != id {
+			t.Errorf("expected rule %s to match %v, got %s", id, a, r.Id)
+		}
+	}
+	if _, ok := Match(rs[:2], action("p", "ls")); ok {
+		t.Error("no rule should match")
+	}
+}
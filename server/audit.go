@@ -0,0 +1,177 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/audit"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"log"
+	"net/http"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Returned when a decision could not be recorded in the audit log.
+var errAuditFailed = errors.New("could not record the decision in the audit log")
+
+const (
+	// How long the rejections of unauthenticated requests are aggregated
+	rejectionInterval = time.Minute
+	// The number of reasons and origins whose first rejection in an interval is recorded, the rejections of other
+	// reasons and origins are only counted
+	maxRejectionKeys = 16
+)
+
+// The reason and origin that rejections of unauthenticated requests are aggregated by.
+type rejectionKey struct {
+	Reason string
+	Origin string
+}
+
+// Aggregates the rejections of unauthenticated requests, so a burst of them can not flood the audit log with entries
+// that are each flushed to disk. The first rejection of every reason and origin in an interval is recorded, the others
+// are counted and recorded in a single entry when the interval ends.
+type rejections struct {
+	sync.Mutex
+	// The number of rejections that were not recorded yet, by the reason and origin that were recorded in this interval
+	counts map[rejectionKey]int
+	// The number of rejections of reasons and origins beyond maxRejectionKeys
+	others int
+}
+
+// Records the decision on an action. Returns errAuditFailed if the decision could not be recorded.
+func (s *Server) auditDecision(req actionRequest, d Decision) error {
+	if s.Audit == nil {
+		return nil
+	}
+	e := audit.Entry{
+		Kind:       audit.KindDecision,
+		ActionHash: req.Action.Hash(),
+		Action:     audit.Redact(req.Action),
+		Session:    req.Session,
+		Policy:     d.Policy.Id(),
+		Source:     d.Source,
+		Decider:    decider(d),
+	}
+	if err := s.Audit.Append(e); err != nil {
+		log.Println(err)
+		return errAuditFailed
+	}
+	return nil
+}
+
+// Records the outcome of an executed action. The command already ran, so errors are only logged.
+func (s *Server) auditExecution(req actionRequest, res executor.Result, err error) {
+	if s.Audit == nil {
+		return
+	}
+	outcome := &audit.Outcome{ExitCode: res.ExitCode, Status: string(res.Status), Signal: res.Signal}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	e := audit.Entry{Kind: audit.KindExecution, ActionHash: req.Action.Hash(), Session: req.Session, Outcome: outcome}
+	if err := s.Audit.Append(e); err != nil {
+		log.Println(err)
+	}
+}
+
+// Records a request that was rejected before its action was decided on. The action is nil if the request was rejected
+// before it was read, then the session is only the one the request claims. The request is rejected anyway, so errors
+// are only logged.
+func (s *Server) auditRejection(session string, a *actions.Action, reason string) {
+	if s.Audit == nil {
+		return
+	}
+	e := audit.Entry{Kind: audit.KindRejection, Session: session, Reason: reason}
+	if a != nil {
+		e.ActionHash, e.Action = a.Hash(), audit.Redact(*a)
+	}
+	if err := s.Audit.Append(e); err != nil {
+		log.Println(err)
+	}
+}
+
+// Records an unauthenticated request that was rejected, eg. because of its origin or an invalid signature. The reason
+// must not contain values of the request, as rejections are aggregated by reason and origin (see rejections).
+func (s *Server) auditUnauthenticated(r *http.Request, reason string) {
+	if s.Audit == nil {
+		return
+	}
+	origin := r.Header.Get("Origin")
+	key := rejectionKey{Reason: reason, Origin: origin}
+	s.rejections.Lock()
+	if s.rejections.counts == nil { // The interval starts
+		s.rejections.counts = map[rejectionKey]int{}
+		time.AfterFunc(rejectionInterval, s.flushRejections)
+	}
+	record := false
+	if count, ok := s.rejections.counts[key]; ok {
+		s.rejections.counts[key] = count + 1
+	} else if len(s.rejections.counts) < maxRejectionKeys {
+		s.rejections.counts[key] = 0
+		record = true
+	} else {
+		s.rejections.others++
+	}
+	s.rejections.Unlock()
+	if record {
+		s.auditRejection(r.Header.Get(SessionHeader), nil, fmt.Sprintf("%s %s from origin %q: %s", r.Method, r.URL.Path, origin, reason))
+	}
+}
+
+// Records the rejections that were counted in the interval that ended, in one entry per reason and origin. The next
+// rejection starts a new interval.
+func (s *Server) flushRejections() {
+	s.rejections.Lock()
+	counts, others := s.rejections.counts, s.rejections.others
+	s.rejections.counts, s.rejections.others = nil, 0
+	s.rejections.Unlock()
+	for key, count := range counts {
+		if count > 0 {
+			s.auditRejection("", nil, fmt.Sprintf("%d more requests from origin %q rejected in %s: %s", count, key.Origin, rejectionInterval, key.Reason))
+		}
+	}
+	if others > 0 {
+		s.auditRejection("", nil, fmt.Sprintf("%d requests of other origins or reasons rejected in %s", others, rejectionInterval))
+	}
+}
+
+// Returns who made the decision: the local user for prompts and the rule id for rules.
+func decider(d Decision) string {
+	switch d.Source {
+	case audit.SourcePrompt:
+		if u, err := user.Current(); err == nil {
+			return u.Username
+		}
+		return "user"
+	case audit.SourceRule:
+		return d.Rule.Id
+	}
+	return ""
+}
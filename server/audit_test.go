@@ -0,0 +1,226 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/audit"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/sessions"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the entries of the audit log at path after verifying it.
+func readAuditLog(t *testing.T, path string) []audit.Entry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := audit.Verify(f); err != nil {
+		t.Error(err)
+	}
+	f.Seek(0, 0)
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Makes sure that every decision and execution is recorded with the source of the decision.
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backstage-hook-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, _, _ := newExecutingTestServer(policies.AllowAlways())
+	defer ts.Close()
+	ts.Config.Handler.(*Server).Audit = log
+	postAction(t, ts, testAction) // Prompted
+	postAction(t, ts, testAction) // Stored
+	log.Close()
+
+	entries := readAuditLog(t, path)
+	expected := []struct {
+		kind   audit.Kind
+		source audit.Source
+	}{
+		{audit.KindDecision, audit.SourcePrompt},
+		{audit.KindExecution, ""},
+		{audit.KindDecision, audit.SourceStored},
+		{audit.KindExecution, ""},
+	}
+	if len(entries) != len(expected) {
+		t.Fatal("expected ", len(expected), " entries, got ", len(entries))
+	}
+	for i, e := range entries {
+		if e.Kind != expected[i].kind || e.Source != expected[i].source || e.ActionHash != testAction.Hash() || e.Session != testSession.Id() {
+			t.Error("unexpected audit entry ", e)
+		}
+	}
+	if entries[0].Decider == "" || entries[0].Action == nil || entries[0].Policy != policies.AllowAlways().Id() {
+		t.Error("decision entry is incomplete: ", entries[0])
+	}
+	if entries[1].Outcome == nil || entries[1].Outcome.ExitCode != 1 {
+		t.Error("execution entry does not contain the outcome: ", entries[1])
+	}
+}
+
+// UI that never answers, requests wait until they are cancelled.
+type silentUI struct{ fakeUI }
+
+func (s *silentUI) Handle(ctx context.Context, req actions.Action, res chan policies.Policy) {}
+
+// Makes sure that rejected requests are recorded and that the values of environment variables and the input of an
+// action are not.
+func TestAuditRejections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backstage-hook-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, _ := newTestServer(policies.Deny())
+	defer ts.Close()
+	srv := ts.Config.Handler.(*Server)
+	srv.Audit = log
+	secret := actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "login", Env: map[string]string{"TOKEN": "hunter2"}, Stdin: "hunter2"}}
+	postAction(t, ts, secret)
+	postSigned(t, ts, sessions.New(testSession.Id(), "wrongsecret"), time.Now(), "forged")
+	postSigned(t, ts, testSession, time.Now(), "replayed")
+	postSigned(t, ts, testSession, time.Now(), "replayed")
+	req, _ := http.NewRequest(http.MethodOptions, ts.URL+ActionsPath, nil)
+	req.Header.Set("Origin", "http://localhost:1234")
+	if res, err := http.DefaultClient.Do(req); err == nil {
+		res.Body.Close()
+	}
+	srv.UI = &silentUI{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := srv.decide(ctx, &actionRequest{Action: secret, Session: testSession.Id()}); err == nil {
+		t.Error("expected the cancelled request not to be decided")
+	}
+	log.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("the audit log contains the values of environment variables or the input: ", string(data))
+	}
+	entries := readAuditLog(t, path)
+	expected := []audit.Kind{audit.KindDecision, audit.KindRejection, audit.KindDecision, audit.KindRejection, audit.KindRejection, audit.KindRejection}
+	if len(entries) != len(expected) {
+		t.Fatal("expected ", len(expected), " entries, got ", len(entries), ": ", string(data))
+	}
+	for i, e := range entries {
+		if e.Kind != expected[i] || (e.Kind == audit.KindRejection && e.Reason == "") {
+			t.Error("unexpected audit entry ", e)
+		}
+	}
+	if a := entries[0].Action; a == nil || a.Command.Env["TOKEN"] != audit.Redacted || a.Command.Stdin != audit.Redacted || entries[0].ActionHash != secret.Hash() {
+		t.Error("the decision does not record the redacted action and its hash: ", entries[0])
+	}
+	if e := entries[5]; e.Action == nil || e.ActionHash != secret.Hash() || e.Session != testSession.Id() {
+		t.Error("the cancelled request is not recorded with its action: ", e)
+	}
+}
+
+// Makes sure that a burst of rejected requests is recorded in a bounded number of entries that still count every
+// rejection.
+func TestAuditRejectionBurst(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backstage-hook-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(nil, &fakeUI{}, nil, "http://localhost:3000")
+	srv.Audit = log
+	const burst = 100
+	for i := 0; i < burst; i++ {
+		req := httptest.NewRequest(http.MethodPost, ActionsPath, strings.NewReader("{}"))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+		req = httptest.NewRequest(http.MethodPost, ActionsPath, nil)
+		req.Header.Set("Origin", fmt.Sprintf("http://localhost:%d", 4000+i))
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	srv.flushRejections()
+	log.Close()
+
+	entries := readAuditLog(t, path)
+	if len(entries) > 2*maxRejectionKeys+1 {
+		t.Fatal("expected at most ", 2*maxRejectionKeys+1, " entries, got ", len(entries))
+	}
+	counted := 0
+	for _, e := range entries {
+		if e.Kind != audit.KindRejection {
+			t.Error("unexpected audit entry ", e)
+		}
+		var n int
+		if _, err := fmt.Sscanf(e.Reason, "%d", &n); err != nil {
+			n = 1
+		}
+		counted += n
+	}
+	if counted != 2*burst {
+		t.Error("expected the entries to count ", 2*burst, " rejections, got ", counted)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/ui"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Path on which a plugin cancels an action, followed by the id of the action
+	CancelPath = "/actions/cancel/"
+)
+
+// Returned when an action was cancelled before it was decided on.
+var errCancelled = errors.New("the action was cancelled")
+
+// An action request that is being decided on or executed.
+type execution struct {
+	req actionRequest
+	// Cancels the decision or kills the command
+	cancel context.CancelFunc
+	// The time the command was started, zero while the action is being decided on
+	started time.Time
+}
+
+// The action requests that are being handled, by id.
+type executions struct {
+	sync.Mutex
+	running map[string]*execution
+}
+
+// Registers the request so it can be cancelled by its id. The returned context is done once the request is cancelled,
+// done must be called when the request was handled.
+func (s *Server) track(ctx context.Context, req actionRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.executions.Lock()
+	s.executions.running[req.Id] = &execution{req: req, cancel: cancel}
+	s.executions.Unlock()
+	return ctx, func() {
+		s.executions.Lock()
+		delete(s.executions.running, req.Id)
+		s.executions.Unlock()
+		cancel()
+	}
+}
+
+// Marks the approved action as started and returns the request to execute it with. The timeout of the action request
+// is used, or the timeout of the server if the action did not request one. The strictest limits of the server and the
+// request apply.
+func (s *Server) start(req actionRequest) executor.Request {
+	s.executions.Lock()
+	if e, ok := s.executions.running[req.Id]; ok {
+		e.started = time.Now()
+	}
+	s.executions.Unlock()
+
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = s.Timeout
+	}
+	return executor.Request{Action: req.Action, Timeout: timeout, Writable: req.Writable, Limits: s.Limits.Min(req.Limits)}
+}
+
+// Returns the executor that runs the action of the request.
+func (s *Server) executorFor(req actionRequest) executor.Executor {
+	if req.Executor == "" {
+		return s.Executor
+	}
+	if e, ok := s.Executors[req.Executor]; ok {
+		return e
+	}
+	return unavailable(req.Executor)
+}
+
+// An executor that was chosen by a rule but is not available in this hook, every execution fails.
+type unavailable string
+
+// See the executor.Executor interface.
+func (u unavailable) Execute(ctx context.Context, req executor.Request) (executor.Result, error) {
+	return executor.Result{}, fmt.Errorf("executor %q is not available", string(u))
+}
+
+// See the executor.Executor interface.
+func (u unavailable) Resolve(a actions.Action) (actions.Executable, error) {
+	return actions.Executable{}, fmt.Errorf("executor %q is not available", string(u))
+}
+
+// Returns the actions that are being executed, see ui.Executions.
+func (s *Server) Running() []ui.Execution {
+	s.executions.Lock()
+	defer s.executions.Unlock()
+	running := []ui.Execution{}
+	for _, e := range s.executions.running {
+		if !e.started.IsZero() {
+			running = append(running, ui.Execution{Id: e.req.Id, Action: e.req.Action, Started: e.started})
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].Started.Before(running[j].Started) })
+	return running
+}
+
+// Cancels the action request with id, whether it is being decided on or executed. See ui.Executions.
+func (s *Server) Cancel(id string) bool {
+	return s.cancel(id, "")
+}
+
+// Cancels the action request with id. If session is not empty, only a request of that session is cancelled.
+func (s *Server) cancel(id string, session string) bool {
+	s.executions.Lock()
+	defer s.executions.Unlock()
+	e, ok := s.executions.running[id]
+	if !ok || (session != "" && e.req.Session != session) {
+		return false
+	}
+	e.cancel()
+	return true
+}
+
+// Handles POST /actions/cancel/<id>, a plugin can only cancel the actions it requested in the same session.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.readSigned(w, r); !ok {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, CancelPath)
+	if !s.cancel(id, r.Header.Get(SessionHeader)) {
+		http.Error(w, "no such action", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Generates a random id for an action request.
+func generateId() (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
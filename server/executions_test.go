@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var sleepAction = actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "sleep"}}
+
+// Waits until the server executes an action and returns its id.
+func waitForExecution(t *testing.T, srv *Server) string {
+	for i := 0; i < 500; i++ {
+		if running := srv.Running(); len(running) > 0 {
+			return running[0].Id
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("the action was not executed")
+	return ""
+}
+
+// Posts a signed cancel request for the action with id and returns the status code.
+func postCancel(t *testing.T, ts *httptest.Server, id string) int {
+	res, err := http.DefaultClient.Do(newAuthenticatedRequest(http.MethodPost, ts.URL+CancelPath+id, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// Makes sure that the timeout of an action overrides the default timeout of the server.
+func TestTimeouts(t *testing.T) {
+	ts, _, exec := newExecutingTestServer(policies.Allow())
+	defer ts.Close()
+	ts.Config.Handler.(*Server).Timeout = time.Minute
+
+	postAction(t, ts, testAction)
+	body, _ := json.Marshal(actionBody{Action: testAction, Timeout: 5})
+	res, err := http.DefaultClient.Do(newAuthenticatedRequest(http.MethodPost, ts.URL+ActionsPath, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if len(exec.timeouts) != 2 || exec.timeouts[0] != time.Minute || exec.timeouts[1] != 5*time.Second {
+		t.Error("unexpected timeouts: ", exec.timeouts)
+	}
+
+	body, _ = json.Marshal(actionBody{Action: testAction, Timeout: -1})
+	if res, err = http.DefaultClient.Do(newAuthenticatedRequest(http.MethodPost, ts.URL+ActionsPath, body)); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Error("negative timeout was accepted")
+	}
+}
+
+// Makes sure that a plugin can cancel a running action by its id.
+func TestCancelStream(t *testing.T) {
+	ts, _ := newTestServer(policies.Allow())
+	defer ts.Close()
+	srv := ts.Config.Handler.(*Server)
+
+	if postCancel(t, ts, "unknown") != http.StatusNotFound {
+		t.Error("cancelling an unknown action should fail")
+	}
+	go func() {
+		id := waitForExecution(t, srv)
+		if srv.cancel(id, "othersession") {
+			t.Error("another session cancelled the action")
+		}
+		if status := postCancel(t, ts, id); status != http.StatusNoContent {
+			t.Error("could not cancel the action: ", status)
+		}
+	}()
+
+	events := streamAction(t, ts, sleepAction)
+	last := events[len(events)-1]
+	if last.name != EventExit || last.frame.Termination != executor.Cancelled || last.frame.Id == "" || last.frame.Id != events[0].frame.Id {
+		t.Error("the exit frame should report the cancellation: ", last)
+	}
+	if len(srv.Running()) != 0 {
+		t.Error("the cancelled action is still running")
+	}
+}
+
+// Makes sure that the user can cancel a running action.
+func TestCancelByUser(t *testing.T) {
+	ts, _ := newTestServer(policies.Allow())
+	defer ts.Close()
+	srv := ts.Config.Handler.(*Server)
+
+	go func() {
+		if !srv.Cancel(waitForExecution(t, srv)) {
+			t.Error("could not cancel the action")
+		}
+	}()
+	r := postAction(t, ts, sleepAction)
+	if r.Result == nil || r.Result.Status != executor.Cancelled || r.Id == "" {
+		t.Error("the response should report the cancellation: ", r)
+	}
+}
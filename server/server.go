@@ -0,0 +1,360 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+// The server receives action requests from Backstage plugins over HTTP,
+// decides whether they may be executed, asking the user through the UI when
+// no decision was stored earlier, and executes the allowed actions.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/audit"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"github.com/tcorp-bv/backstage-hook/storage"
+	"github.com/tcorp-bv/backstage-hook/ui"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Path on which action requests are accepted
+	ActionsPath = "/actions"
+	// Path on which action requests are accepted and their progress is streamed back as server-sent events
+	StreamPath = "/actions/stream"
+	// Maximum size of a request body, larger requests are rejected
+	maxBodySize = 1 << 20
+)
+
+var (
+	// Returned when the timeout of an action is negative
+	errNegativeTimeout = errors.New("timeout must not be negative")
+	// Returned when a plugin sends the executable of an action, only the hook resolves it
+	errExecutableSet = errors.New("the executable of an action is resolved by the hook and must not be sent")
+	// Returned when a plugin sends the variables that are passed to an action, only the hook decides which are passed
+	errPassEnvSet = errors.New("the passed environment variables of an action are decided by the hook and must not be sent")
+)
+
+// Response is the body that is returned to the plugin for every action request.
+type Response struct {
+	// The id that the hook assigned to the request
+	Id string `json:"id"`
+	// The hash of the requested action, this allows the plugin to correlate responses
+	Hash string `json:"hash"`
+	// The Id of the policy that was applied (eg. ALLOW_ALWAYS)
+	Policy string `json:"policy"`
+	// Whether the action was allowed to execute
+	Allowed bool `json:"allowed"`
+	// The result of the execution, only set if the action was allowed and executed
+	Result *executor.Result `json:"result,omitempty"`
+	// Set if the action was cancelled before it was decided on, or if it was allowed but could not be executed
+	Error string `json:"error,omitempty"`
+}
+
+// Server is the http.Handler that accepts actions from Backstage. It is recommended that a new Server is created
+// through server.New().
+type Server struct {
+	// Stores the policies of earlier decisions (eg. allow always)
+	Store storage.Store
+	// The frontend that asks the user to approve an action
+	UI ui.UI
+	// Executes the allowed actions, unless the deciding rule chose another executor
+	Executor executor.Executor
+	// The executors that rules can choose by name (eg. executor.Sandbox)
+	Executors map[string]executor.Executor
+	// Remembers the nonces of signed requests to reject replays
+	Nonces storage.Nonces
+	// Records every decision and execution, nothing is recorded if nil
+	Audit *audit.Log
+	// The origin (eg. http://localhost:3000) of the Backstage instance that is allowed to send requests
+	Origin string
+	// Commands that run longer are killed, unless the action requests another timeout. There is no timeout if zero.
+	Timeout time.Duration
+	// The names of the variables in the environment of the hook that are passed to every command, rules can pass more.
+	// No other variables are passed.
+	PassEnv []string
+	// The resource limits of every command, rules can set stricter limits
+	Limits executor.Limits
+
+	mux        *http.ServeMux
+	pairings   pairings
+	executions executions
+	rejections rejections
+}
+
+// Creates a new Server that stores decisions in store, prompts the user through frontend and runs allowed actions with
+// exec. Only browser requests from origin (the Backstage url) are accepted. Commands get the minimal environment of
+// executor.MinimalEnv.
+func New(store storage.Store, frontend ui.UI, exec executor.Executor, origin string) *Server {
+	s := &Server{Store: store, UI: frontend, Executor: exec, Nonces: storage.NewMemoryNonceStorage(), Origin: origin, PassEnv: executor.MinimalEnv, mux: http.NewServeMux()}
+	s.Executors = map[string]executor.Executor{executor.Local: exec}
+	s.pairings.pending = map[string]*pairing{}
+	s.executions.running = map[string]*execution{}
+	s.mux.HandleFunc(ActionsPath, s.handleAction)
+	s.mux.HandleFunc(StreamPath, s.handleStream)
+	s.mux.HandleFunc(CancelPath, s.handleCancel)
+	s.mux.HandleFunc(SessionsPath, s.handleSessions)
+	return s
+}
+
+// Serves the http request after checking its origin.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if origin != s.Origin {
+			s.auditUnauthenticated(r, "origin not allowed")
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", s.Origin)
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join([]string{"Content-Type", SessionHeader, TimestampHeader, NonceHeader, SignatureHeader}, ", "))
+		w.Header().Set("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions { // CORS preflight
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// A request to execute an action, read from an authenticated http request.
+type actionRequest struct {
+	// Identifies the request, eg. to cancel it
+	Id string
+	// The requested action
+	Action actions.Action
+	// The id of the session that signed the request
+	Session string
+	// Overrides the timeout of the server if not zero
+	Timeout time.Duration
+	// The name of the executor that runs the action, the Executor of the server if empty
+	Executor string
+	// The directories the action may write to, if its executor restricts writes
+	Writable []string
+	// The resource limits that the deciding rule set
+	Limits executor.Limits
+}
+
+// The JSON body of an action request.
+type actionBody struct {
+	actions.Action
+	// The number of seconds after which the command is killed, the timeout of the server is used if zero
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// A decision on an action and where it came from.
+type Decision struct {
+	// The applied policy
+	Policy policies.Policy
+	// Whether the user, a stored policy or a rule decided
+	Source audit.Source
+	// The rule that decided, only set if Source is audit.SourceRule
+	Rule *rules.Rule
+}
+
+// Decides on the policy of an action. A stored policy is used if one exists, otherwise the user is asked through the
+// UI. Blocks until the user made a decision or ctx is done.
+func (s *Server) Decide(ctx context.Context, a actions.Action) (Decision, error) {
+	if m, contains := s.Store.Match(a); contains {
+		if m.Rule != nil {
+			return Decision{Policy: m.Policy, Source: audit.SourceRule, Rule: m.Rule}, nil
+		}
+		return Decision{Policy: m.Policy, Source: audit.SourceStored}, nil
+	}
+
+	res := make(chan policies.Policy, 1) // Buffered so the UI never blocks when the request was abandoned
+	s.UI.Handle(ctx, a, res)
+	select {
+	case p := <-res:
+		s.remember(a, p)
+		return Decision{Policy: p, Source: audit.SourcePrompt}, nil
+	case <-ctx.Done():
+		return Decision{}, ctx.Err()
+	}
+}
+
+// Decides on the action of the request and records the decision in the audit log. The variables that a deciding rule
+// passes are added to the action and its executor and limits are used. If the decision can not be recorded, an error is
+// returned and the action must not be executed.
+func (s *Server) decide(ctx context.Context, req *actionRequest) (Decision, error) {
+	d, err := s.Decide(ctx, req.Action)
+	if err != nil {
+		s.auditRejection(req.Session, &req.Action, fmt.Sprintf("cancelled before a decision: %v", err))
+		return d, err
+	}
+	if d.Rule != nil && d.Policy.Allows() {
+		req.Action.PassEnv = passEnv(req.Action.PassEnv, d.Rule.PassEnv)
+		req.Executor, req.Writable = d.Rule.Executor, d.Rule.WritableDirs()
+		if d.Rule.Limits != nil {
+			req.Limits = *d.Rule.Limits
+		}
+	}
+	return d, s.auditDecision(*req, d)
+}
+
+// Returns the names that are set in the environment of the hook, out of both lists, sorted and without duplicates.
+// Only these are shown to the user, the others would not be passed anyway.
+func passEnv(names []string, more []string) []string {
+	set := map[string]bool{}
+	var passed []string
+	for _, name := range append(append([]string{}, names...), more...) {
+		if _, ok := os.LookupEnv(name); ok && !set[name] {
+			set[name] = true
+			passed = append(passed, name)
+		}
+	}
+	sort.Strings(passed)
+	return passed
+}
+
+// Stores the decision of the user if it also applies to future actions.
+func (s *Server) remember(a actions.Action, p policies.Policy) {
+	switch p {
+	case policies.AllowAlways(), policies.AllowFor15Minutes(), policies.AllowUntilRestart(), policies.DenyAlways():
+		s.Store.SetPolicy(a, p)
+	case policies.AllowSimilar():
+		_ = s.Store.AddRule(rules.FromAction(a, rules.Allow)) // Generated rules are always valid
+	}
+}
+
+// Handles a JSON encoded actions.Action, executes it if allowed and writes the decision and result as a Response.
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.readAction(w, r)
+	if !ok {
+		return
+	}
+	ctx, done := s.track(r.Context(), req)
+	defer done()
+
+	d, err := s.decide(ctx, &req)
+	if err == errAuditFailed {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		if r.Context().Err() == nil { // Cancelled while the client is still waiting
+			writeJSON(w, http.StatusOK, Response{Id: req.Id, Hash: req.Action.Hash(), Error: errCancelled.Error()})
+		}
+		return
+	}
+	res := Response{Id: req.Id, Hash: req.Action.Hash(), Policy: d.Policy.Id(), Allowed: d.Policy.Allows()}
+	if d.Policy.Allows() {
+		result, err := executor.Capture(ctx, s.executorFor(req), s.start(req))
+		s.auditExecution(req, result, err)
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Result = &result
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Reads the action from a POST request that is signed by a session, resolves its executable and environment and assigns
+// it an id. If the request is invalid, an error response is written and false is returned.
+func (s *Server) readAction(w http.ResponseWriter, r *http.Request) (actionRequest, bool) {
+	body, ok := s.readSigned(w, r)
+	if !ok {
+		return actionRequest{}, false
+	}
+	req, err := decodeAction(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return actionRequest{}, false
+	}
+	exe, err := s.Executor.Resolve(req.Action)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return actionRequest{}, false
+	}
+	req.Action.Executable = &exe
+	req.Action.PassEnv = passEnv(s.PassEnv, nil)
+	if req.Id, err = generateId(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return actionRequest{}, false
+	}
+	req.Session = r.Header.Get(SessionHeader)
+	return req, true
+}
+
+// Reads the body of a POST request that is signed by a session. If the request is invalid, an error response is
+// written and false is returned.
+func (s *Server) readSigned(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := s.authenticate(r, body); err != nil {
+		s.auditUnauthenticated(r, err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
+
+// Decodes the action and its options in the request body.
+func decodeAction(body []byte) (actionRequest, error) {
+	var b actionBody
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return actionRequest{}, err
+	}
+	if err := b.Command.Validate(); err != nil {
+		return actionRequest{}, err
+	}
+	if b.Executable != nil {
+		return actionRequest{}, errExecutableSet
+	}
+	if b.PassEnv != nil {
+		return actionRequest{}, errPassEnvSet
+	}
+	if b.Timeout < 0 {
+		return actionRequest{}, errNegativeTimeout
+	}
+	return actionRequest{Action: b.Action, Timeout: time.Duration(b.Timeout) * time.Second}, nil
+}
+
+// Writes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,441 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"github.com/tcorp-bv/backstage-hook/sessions"
+	"github.com/tcorp-bv/backstage-hook/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testOrigin = "http://localhost:3000"
+
+// The session that is paired with every test server.
+var testSession = sessions.New("testsession", "testsecret")
+
+// UI that answers every request with the same policy and counts how often it was asked.
+type fakeUI struct {
+	sync.Mutex
+	policy  policies.Policy
+	handled int
+	// The pairing codes the user was asked to confirm
+	codes []string
+}
+
+func (f *fakeUI) Handle(ctx context.Context, req actions.Action, res chan policies.Policy) {
+	f.Lock()
+	defer f.Unlock()
+	f.handled++
+	res <- f.policy
+}
+
+func (f *fakeUI) Pair(ctx context.Context, code string, res chan bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.codes = append(f.codes, code)
+	res <- f.policy.Allows()
+}
+
+func (f *fakeUI) Setup() {}
+
+func (f *fakeUI) Close() {}
+
+func (f *fakeUI) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.handled
+}
+
+// Executor that records the executed actions instead of running them.
+type fakeExecutor struct {
+	sync.Mutex
+	executed []actions.Action
+	timeouts []time.Duration
+	writable [][]string
+	limits   []executor.Limits
+	// The digest of every resolved executable, changing it replaces all executables
+	digest string
+}
+
+func (f *fakeExecutor) Resolve(a actions.Action) (actions.Executable, error) {
+	if a.Command.Name == "missing" {
+		return actions.Executable{}, errors.New("executable file not found")
+	}
+	f.Lock()
+	defer f.Unlock()
+	return actions.Executable{Path: "/fake/bin/" + a.Command.Name, Digest: f.digest}, nil
+}
+
+func (f *fakeExecutor) replace(digest string) {
+	f.Lock()
+	defer f.Unlock()
+	f.digest = digest
+}
+
+func (f *fakeExecutor) Execute(ctx context.Context, req executor.Request) (executor.Result, error) {
+	a := req.Action
+	f.Lock()
+	f.executed = append(f.executed, a)
+	f.timeouts = append(f.timeouts, req.Timeout)
+	f.writable = append(f.writable, req.Writable)
+	f.limits = append(f.limits, req.Limits)
+	f.Unlock()
+	switch a.Command.Name {
+	case "fail":
+		return executor.Result{}, errors.New("could not start")
+	case "sleep": // Runs until it is cancelled
+		<-ctx.Done()
+		return executor.Result{ExitCode: -1, Status: executor.Cancelled, Signal: "killed"}, nil
+	}
+	io.WriteString(req.Stdout, a.Command.Name)
+	io.WriteString(req.Stderr, "err")
+	io.WriteString(req.Stdout, " "+strings.Join(a.Command.Args, " "))
+	return executor.Result{ExitCode: len(a.Command.Args), Status: executor.Exited}, nil
+}
+
+func (f *fakeExecutor) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.executed)
+}
+
+// Starts a test server that answers prompts with pol.
+func newTestServer(pol policies.Policy) (*httptest.Server, *fakeUI) {
+	ts, frontend, _ := newExecutingTestServer(pol)
+	return ts, frontend
+}
+
+// Starts a test server that answers prompts with pol and records executions.
+func newExecutingTestServer(pol policies.Policy) (*httptest.Server, *fakeUI, *fakeExecutor) {
+	frontend, exec := &fakeUI{policy: pol}, &fakeExecutor{}
+	store := storage.New(storage.NewMemoryPolicyStorage(), storage.NewMemorySessionStorage())
+	store.SetSession(testSession)
+	return httptest.NewServer(New(store, frontend, exec, testOrigin)), frontend, exec
+}
+
+// Counter to generate unique nonces.
+var nonceCounter int64
+
+// Creates a request that is signed by the test session.
+func newAuthenticatedRequest(method string, url string, body []byte) *http.Request {
+	req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	nonce := strconv.FormatInt(atomic.AddInt64(&nonceCounter, 1), 10)
+	signRequest(req, testSession, body, time.Now(), nonce)
+	return req
+}
+
+// Sets the signature headers of the request for the session.
+func signRequest(req *http.Request, s sessions.Session, body []byte, sent time.Time, nonce string) {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	req.Header.Set(SessionHeader, s.Id())
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, sessions.Sign(s.Secret(), req.Method, req.URL.Path, body, timestamp, nonce))
+}
+
+// Posts the action to the test server and decodes the response.
+func postAction(t *testing.T, ts *httptest.Server, a actions.Action) Response {
+	body, _ := json.Marshal(a)
+	res, err := http.DefaultClient.Do(newAuthenticatedRequest(http.MethodPost, ts.URL+ActionsPath, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status code ", res.StatusCode)
+	}
+	var r Response
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+var testAction = actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "git", Args: []string{"status"}}}
+
+// Makes sure that allow and deny decisions of the UI are returned to the plugin.
+func TestDecisions(t *testing.T) {
+	for _, pol := range []policies.Policy{policies.Allow(), policies.Deny()} {
+		ts, frontend := newTestServer(pol)
+		r := postAction(t, ts, testAction)
+		if r.Policy != pol.Id() || r.Allowed != pol.Allows() || r.Hash != testAction.Hash() {
+			t.Error("response does not match the decision of the UI: ", r)
+		}
+		postAction(t, ts, testAction)
+		if frontend.count() != 2 {
+			t.Error("a one time decision should prompt the user every time")
+		}
+		ts.Close()
+	}
+}
+
+// Makes sure that the user is only prompted once for an action that is allowed for a while or always.
+func TestAllowAlwaysIsStored(t *testing.T) {
+	for _, pol := range []policies.Policy{policies.AllowAlways(), policies.AllowFor15Minutes(), policies.AllowUntilRestart()} {
+		ts, frontend := newTestServer(pol)
+		for i := 0; i < 3; i++ {
+			r := postAction(t, ts, testAction)
+			if !r.Allowed || r.Policy != pol.Id() {
+				t.Error("stored action was not allowed by ", pol.Id())
+			}
+		}
+		if frontend.count() != 1 {
+			t.Error("user was prompted ", frontend.count(), " times for ", pol.Id(), ", expected once")
+		}
+		ts.Close()
+	}
+}
+
+// Makes sure that an always denied action is rejected without prompting the user again.
+func TestDenyAlwaysIsStored(t *testing.T) {
+	ts, frontend, exec := newExecutingTestServer(policies.DenyAlways())
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		r := postAction(t, ts, testAction)
+		if r.Allowed || r.Policy != policies.DenyAlways().Id() || r.Result != nil {
+			t.Error("always denied action was not denied: ", r)
+		}
+	}
+	if frontend.count() != 1 || exec.count() != 0 {
+		t.Error("user was prompted ", frontend.count(), " times, expected once")
+	}
+}
+
+// Makes sure that a stored approval does not carry over to a replaced executable, while a stored denial does.
+func TestExecutablePinned(t *testing.T) {
+	for _, pol := range []policies.Policy{policies.AllowAlways(), policies.DenyAlways()} {
+		ts, frontend, exec := newExecutingTestServer(pol)
+		postAction(t, ts, testAction)
+		exec.replace("swapped")
+		postAction(t, ts, testAction)
+		prompts := 1
+		if pol.Allows() {
+			prompts = 2
+		}
+		if frontend.count() != prompts {
+			t.Error("user was prompted ", frontend.count(), " times for ", pol.Id(), ", expected ", prompts)
+		}
+		ts.Close()
+	}
+
+	ts, _, exec := newExecutingTestServer(policies.Allow())
+	defer ts.Close()
+	postAction(t, ts, testAction)
+	if exe := exec.executed[0].Executable; exe == nil || exe.Path != "/fake/bin/git" {
+		t.Error("the executable was not resolved before executing: ", exe)
+	}
+}
+
+// Makes sure that only the configured variables and the variables of a deciding rule are passed to a command.
+func TestPassEnv(t *testing.T) {
+	os.Setenv("BACKSTAGE_HOOK_ALLOWED", "allowed")
+	os.Setenv("BACKSTAGE_HOOK_TOKEN", "token")
+	defer os.Unsetenv("BACKSTAGE_HOOK_ALLOWED")
+	defer os.Unsetenv("BACKSTAGE_HOOK_TOKEN")
+
+	exec := &fakeExecutor{}
+	store := storage.New(storage.NewMemoryPolicyStorage(), storage.NewMemorySessionStorage())
+	store.SetSession(testSession)
+	_ = store.AddRule(rules.Rule{Id: "gh", Command: "gh", Args: []rules.Pattern{rules.AnyArgs}, PassEnv: []string{"BACKSTAGE_HOOK_TOKEN"}, Decision: rules.Allow})
+	srv := New(store, &fakeUI{policy: policies.Allow()}, exec, testOrigin)
+	srv.PassEnv = []string{"BACKSTAGE_HOOK_ALLOWED", "BACKSTAGE_HOOK_UNSET"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	postAction(t, ts, testAction)
+	postAction(t, ts, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "gh", Args: []string{"pr", "list"}}})
+	exec.Lock()
+	defer exec.Unlock()
+	if env := exec.executed[0].PassEnv; len(env) != 1 || env[0] != "BACKSTAGE_HOOK_ALLOWED" {
+		t.Error("unexpected variables passed to a prompted action: ", env)
+	}
+	if env := exec.executed[1].PassEnv; len(env) != 2 || env[0] != "BACKSTAGE_HOOK_ALLOWED" || env[1] != "BACKSTAGE_HOOK_TOKEN" {
+		t.Error("the variables of the rule were not passed: ", env)
+	}
+}
+
+// Makes sure that an action runs with the executor and writable directories of the deciding rule.
+func TestRuleExecutor(t *testing.T) {
+	local, sandbox := &fakeExecutor{}, &fakeExecutor{}
+	store := storage.New(storage.NewMemoryPolicyStorage(), storage.NewMemorySessionStorage())
+	store.SetSession(testSession)
+	_ = store.AddRule(rules.Rule{Id: "make", Command: "make", Executor: executor.Sandbox, Writable: []string{"/src"}, Limits: &executor.Limits{CPU: 10, Memory: 4 << 30}, Decision: rules.Allow})
+	_ = store.AddRule(rules.Rule{Id: "ls", Command: "ls", Executor: executor.Local, Decision: rules.Allow})
+	srv := New(store, &fakeUI{policy: policies.Allow()}, local, testOrigin)
+	srv.Limits = executor.Limits{CPU: 60, Memory: 1 << 30}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	r := postAction(t, ts, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "make"}})
+	if r.Error == "" || local.count() != 0 {
+		t.Error("an action for an unavailable executor was executed: ", r)
+	}
+	srv.Executors[executor.Sandbox] = sandbox
+	postAction(t, ts, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "make"}})
+	postAction(t, ts, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}})
+	postAction(t, ts, testAction)
+	if sandbox.count() != 1 || local.count() != 2 {
+		t.Error("actions did not run with the executor of their rule: ", sandbox.count(), local.count())
+	}
+	sandbox.Lock()
+	defer sandbox.Unlock()
+	if len(sandbox.writable) != 1 || len(sandbox.writable[0]) != 1 || sandbox.writable[0][0] != "/src" {
+		t.Error("the writable directories of the rule were not passed: ", sandbox.writable)
+	}
+	if len(sandbox.limits) != 1 || sandbox.limits[0] != (executor.Limits{CPU: 10, Memory: 1 << 30}) {
+		t.Error("the strictest limits of the rule and the server were not applied: ", sandbox.limits)
+	}
+	local.Lock()
+	defer local.Unlock()
+	if local.limits[0] != srv.Limits {
+		t.Error("the limits of the server were not applied: ", local.limits)
+	}
+}
+
+// Makes sure that allowing similar actions creates a rule that also covers the similar actions.
+func TestAllowSimilarCreatesRule(t *testing.T) {
+	ts, frontend := newTestServer(policies.AllowSimilar())
+	defer ts.Close()
+
+	postAction(t, ts, testAction)
+	similar := testAction
+	similar.Command.Args = []string{"status", "--short"}
+	r := postAction(t, ts, similar)
+	if !r.Allowed || r.Policy != policies.AllowSimilar().Id() {
+		t.Error("similar action was not allowed")
+	}
+	if frontend.count() != 1 {
+		t.Error("user was prompted ", frontend.count(), " times, expected once")
+	}
+}
+
+// Makes sure that a rule created with AllowSimilar does not allow another executable.
+func TestAllowSimilarPinned(t *testing.T) {
+	ts, frontend, exec := newExecutingTestServer(policies.AllowSimilar())
+	defer ts.Close()
+
+	postAction(t, ts, testAction)
+	exec.replace("swapped")
+	postAction(t, ts, testAction)
+	if frontend.count() != 2 {
+		t.Error("user was prompted ", frontend.count(), " times, expected twice")
+	}
+}
+
+// Makes sure that only allowed actions are executed and that their result is returned.
+func TestExecution(t *testing.T) {
+	ts, _, exec := newExecutingTestServer(policies.Allow())
+	r := postAction(t, ts, testAction)
+	if r.Result == nil || r.Result.Stdout != "git status" || r.Result.ExitCode != 1 || r.Error != "" {
+		t.Error("execution result was not returned: ", r)
+	}
+	r = postAction(t, ts, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "fail"}})
+	if r.Result != nil || r.Error == "" {
+		t.Error("execution error was not returned: ", r)
+	}
+	ts.Close()
+
+	ts, _, exec = newExecutingTestServer(policies.Deny())
+	defer ts.Close()
+	r = postAction(t, ts, testAction)
+	if r.Result != nil || exec.count() != 0 {
+		t.Error("denied action was executed")
+	}
+}
+
+// Makes sure that invalid requests are rejected before they reach the UI.
+func TestInvalidRequests(t *testing.T) {
+	ts, frontend := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	cases := []struct {
+		method, origin, body string
+		status               int
+	}{
+		{http.MethodGet, "", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", "not json", http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":""}}`, http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":"ls"},"unknown":1}`, http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":"missing"}}`, http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":"ls","dir":"src"}}`, http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":"ls"},"passEnv":["GITHUB_TOKEN"]}`, http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":"ls","env":{"A=B":"c"}}}`, http.StatusBadRequest},
+		{http.MethodPost, "", `{"plugin":"test","command":{"name":"ls"},"executable":{"path":"/bin/ls"}}`, http.StatusBadRequest},
+		{http.MethodPost, "http://evil.example.com", `{"plugin":"test","command":{"name":"ls"}}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := newAuthenticatedRequest(c.method, ts.URL+ActionsPath, []byte(c.body))
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Error("expected status ", c.status, ", got ", res.StatusCode, " for ", c)
+		}
+	}
+	if frontend.count() != 0 {
+		t.Error("invalid requests should never reach the UI")
+	}
+}
+
+// Makes sure that requests from the Backstage origin are allowed by CORS.
+func TestCorsPreflight(t *testing.T) {
+	ts, _ := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodOptions, ts.URL+ActionsPath, nil)
+	req.Header.Set("Origin", testOrigin)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Access-Control-Allow-Origin") != testOrigin {
+		t.Error("preflight request from the Backstage origin was not allowed")
+	}
+}
@@ -0,0 +1,261 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/sessions"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Path on which Backstage starts pairing, followed by the session id to receive the secret of a confirmed pairing
+	SessionsPath = "/sessions/"
+	// Header that contains the session id on action requests
+	SessionHeader = "X-Backstage-Hook-Session"
+	// Header that contains the time of an action request in seconds since the unix epoch
+	TimestampHeader = "X-Backstage-Hook-Timestamp"
+	// Header that contains a random string that is unique for every action request
+	NonceHeader = "X-Backstage-Hook-Nonce"
+	// Header that contains the signature of an action request, see sessions.Sign
+	SignatureHeader = "X-Backstage-Hook-Signature"
+	// Requests with a timestamp that differs more from the time of the hook are rejected
+	maxClockSkew = 5 * time.Minute
+	// Maximum length of a nonce
+	maxNonceLength = 128
+	// Time the user has to confirm a pairing code
+	pairingTimeout = 5 * time.Minute
+	// Number of digits of a pairing code
+	pairingCodeDigits = 6
+)
+
+var (
+	// Returned when a request does not contain the session id or signature headers
+	errMissingCredentials = errors.New("missing session credentials")
+	// Returned when the session does not exist or the signature does not match
+	errInvalidSignature = errors.New("invalid session or signature")
+	// Returned when the timestamp of a request is too far from the current time
+	errStaleRequest = errors.New("request timestamp is too old or in the future")
+	// Returned when a nonce was used before
+	errReplayedRequest = errors.New("request nonce was already used")
+	// Returned when the result of a pairing is requested that is not pending
+	errNoPairing = errors.New("no pairing in progress for this session")
+	// Returned when another request already waits for the result of the pairing
+	errPairingWaited = errors.New("another request already waits for this pairing")
+)
+
+// Returned to Backstage when it starts pairing.
+type PairingResponse struct {
+	// The id of the session that is created once the user confirms the code
+	Id string `json:"id"`
+	// The code that Backstage shows to the user, the user confirms it in the hook
+	Code string `json:"code"`
+}
+
+// Returned to Backstage once the user confirmed the pairing.
+type SessionResponse struct {
+	// The session id, sent in the SessionHeader of every action request
+	Id string `json:"id"`
+	// The session secret, used to sign every action request
+	Secret string `json:"secret"`
+}
+
+// A pairing that was started by Backstage.
+type pairing struct {
+	// The session that is stored once the user confirms the code
+	session sessions.Session
+	// The code shown to the user
+	code string
+	// Receives the decision of the user
+	confirmed chan bool
+	// Withdraws the pairing request from the UI, this happens by itself after pairingTimeout
+	withdraw context.CancelFunc
+	// The origin of the request that started pairing, only one pairing per origin can be pending
+	origin string
+	// Whether a request waits for the decision, only one request at a time receives the result
+	waiting bool
+}
+
+// The pairings that are waiting for Backstage to receive the result, by session id.
+type pairings struct {
+	sync.Mutex
+	pending map[string]*pairing
+}
+
+// Handles the pairing handshake:
+//   - POST /sessions/ starts pairing and returns a PairingResponse, the user is asked to confirm the code in the UI.
+//   - POST /sessions/<id> waits until the user decided. If the user confirmed, a session is stored and its
+//     credentials are returned as a SessionResponse. Otherwise the request is rejected.
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, SessionsPath)
+	if id == "" {
+		s.startPairing(w, r)
+		return
+	}
+	s.finishPairing(w, r, id)
+}
+
+// Generates a session id and pairing code and asks the user to confirm the code. The request is rejected if a pairing
+// that was started from the same origin is still pending, so the user is not flooded with pairing requests.
+func (s *Server) startPairing(w http.ResponseWriter, r *http.Request) {
+	session, err := sessions.Generate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code, err := generatePairingCode()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	origin := r.Header.Get("Origin")
+	s.pairings.Lock()
+	for _, pending := range s.pairings.pending {
+		if pending.origin == origin {
+			s.pairings.Unlock()
+			http.Error(w, "a pairing is already in progress", http.StatusTooManyRequests)
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pairingTimeout)
+	p := &pairing{session: session, code: code, confirmed: make(chan bool, 1), withdraw: cancel, origin: origin}
+	s.pairings.pending[session.Id()] = p
+	s.pairings.Unlock()
+	time.AfterFunc(pairingTimeout, func() { s.removePairing(session.Id()) })
+
+	s.UI.Pair(ctx, code, p.confirmed)
+	writeJSON(w, http.StatusOK, PairingResponse{Id: session.Id(), Code: code})
+}
+
+// Waits for the decision of the user and issues the session secret if the user confirmed the code. If Backstage stops
+// waiting before the user decided, the pairing stays pending so Backstage can request the result again.
+func (s *Server) finishPairing(w http.ResponseWriter, r *http.Request, id string) {
+	p, err := s.waitPairing(id)
+	if err == errPairingWaited {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var confirmed bool
+	select {
+	case confirmed = <-p.confirmed:
+	case <-r.Context().Done():
+		s.pairings.Lock()
+		p.waiting = false
+		s.pairings.Unlock()
+		return
+	case <-time.After(pairingTimeout):
+	}
+	if confirmed {
+		s.Store.SetSession(p.session)
+	}
+	s.removePairing(id) // The result is recorded, so it can only be received once
+	p.withdraw()
+	if !confirmed {
+		http.Error(w, "pairing was not confirmed", http.StatusForbidden)
+		return
+	}
+	writeJSON(w, http.StatusOK, SessionResponse{Id: p.session.Id(), Secret: p.session.Secret()})
+}
+
+// Marks the pending pairing as waited for. Returns errNoPairing if there is no such pairing and errPairingWaited if
+// another request already waits for it.
+func (s *Server) waitPairing(id string) (*pairing, error) {
+	s.pairings.Lock()
+	defer s.pairings.Unlock()
+	p := s.pairings.pending[id]
+	if p == nil {
+		return nil, errNoPairing
+	}
+	if p.waiting {
+		return nil, errPairingWaited
+	}
+	p.waiting = true
+	return p, nil
+}
+
+// Removes the pending pairing, this allows another pairing from its origin.
+func (s *Server) removePairing(id string) {
+	s.pairings.Lock()
+	defer s.pairings.Unlock()
+	delete(s.pairings.pending, id)
+}
+
+// Checks that the request with the given body is signed by a stored session, is recent and was not sent before.
+func (s *Server) authenticate(r *http.Request, body []byte) error {
+	id, timestamp := r.Header.Get(SessionHeader), r.Header.Get(TimestampHeader)
+	nonce, signature := r.Header.Get(NonceHeader), r.Header.Get(SignatureHeader)
+	if id == "" || timestamp == "" || nonce == "" || signature == "" {
+		return errMissingCredentials
+	}
+	session, ok := s.Store.Session(id)
+	if !ok || !session.Verify(signature, r.Method, r.URL.Path, body, timestamp, nonce) {
+		return errInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errInvalidSignature
+	}
+	sent := time.Unix(seconds, 0)
+	if skew := time.Since(sent); skew > maxClockSkew || skew < -maxClockSkew {
+		return errStaleRequest
+	}
+	// Once the timestamp is stale, the request is rejected anyway: the nonce only has to be remembered until then.
+	if len(nonce) > maxNonceLength || !s.Nonces.Use(id+":"+nonce, sent.Add(maxClockSkew)) {
+		return errReplayedRequest
+	}
+	return nil
+}
+
+// Generates a random numeric code that is easy to compare for the user.
+func generatePairingCode() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	max := uint64(1)
+	for i := 0; i < pairingCodeDigits; i++ {
+		max *= 10
+	}
+	return fmt.Sprintf("%0*d", pairingCodeDigits, binary.BigEndian.Uint64(b[:])%max), nil
+}
@@ -0,0 +1,281 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/sessions"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Runs the pairing handshake and returns the status code of the final request.
+func pair(t *testing.T, ts *httptest.Server) (PairingResponse, SessionResponse, int) {
+	res, err := http.Post(ts.URL+SessionsPath, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pairing PairingResponse
+	err = json.NewDecoder(res.Body).Decode(&pairing)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatal("could not start pairing ", res.StatusCode, err)
+	}
+
+	res, err = http.Post(ts.URL+SessionsPath+pairing.Id, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var session SessionResponse
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(&session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pairing, session, res.StatusCode
+}
+
+// Sends an action request signed with the given session at the given time and returns the status code.
+func postSigned(t *testing.T, ts *httptest.Server, s sessions.Session, sent time.Time, nonce string) int {
+	body := []byte(`{"plugin":"p","command":{"name":"ls"}}`)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+ActionsPath, bytes.NewReader(body))
+	signRequest(req, s, body, sent, nonce)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// Makes sure that a confirmed pairing issues credentials that are accepted on action requests.
+func TestPairingConfirmed(t *testing.T) {
+	ts, frontend := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	pairing, session, status := pair(t, ts)
+	if status != http.StatusOK {
+		t.Fatal("confirmed pairing was rejected: ", status)
+	}
+	if len(frontend.codes) != 1 || frontend.codes[0] != pairing.Code || len(pairing.Code) != pairingCodeDigits {
+		t.Error("the user was not shown the pairing code")
+	}
+	if session.Id != pairing.Id || session.Secret == "" {
+		t.Error("unexpected session: ", session)
+	}
+	if postSigned(t, ts, sessions.New(session.Id, session.Secret), time.Now(), "nonce") != http.StatusOK {
+		t.Error("request with the paired credentials was rejected")
+	}
+
+	// The secret can only be received once
+	res, _ := http.Post(ts.URL+SessionsPath+pairing.Id, "application/json", nil)
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("pairing result should only be received once")
+	}
+}
+
+// Makes sure that no session is created when the user rejects the code.
+func TestPairingRejected(t *testing.T) {
+	ts, _ := newTestServer(policies.Deny())
+	defer ts.Close()
+
+	pairing, _, status := pair(t, ts)
+	if status != http.StatusForbidden {
+		t.Error("rejected pairing should be forbidden: ", status)
+	}
+	if postSigned(t, ts, sessions.New(pairing.Id, ""), time.Now(), "nonce") != http.StatusUnauthorized {
+		t.Error("request for a rejected pairing was accepted")
+	}
+}
+
+// Posts to the sessions path with the given origin and returns the response.
+func postSessions(t *testing.T, ts *httptest.Server, path, origin string) *http.Response {
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+SessionsPath+path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// Makes sure that only one pairing per origin is pending, so the user can not be flooded with pairing requests.
+func TestPairingPerOrigin(t *testing.T) {
+	ts, frontend := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	res := postSessions(t, ts, "", "")
+	var pairing PairingResponse
+	err := json.NewDecoder(res.Body).Decode(&pairing)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatal("could not start pairing ", res.StatusCode, err)
+	}
+	for _, origin := range []string{"", testOrigin} {
+		res := postSessions(t, ts, "", origin)
+		res.Body.Close()
+		if expected := map[bool]int{true: http.StatusTooManyRequests, false: http.StatusOK}[origin == ""]; res.StatusCode != expected {
+			t.Errorf("expected %d when pairing from origin %q, got %d", expected, origin, res.StatusCode)
+		}
+	}
+	if len(frontend.codes) != 2 {
+		t.Error("expected the user to be asked to confirm 2 pairings, got ", len(frontend.codes))
+	}
+
+	res = postSessions(t, ts, pairing.Id, "")
+	res.Body.Close()
+	res = postSessions(t, ts, "", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Error("pairing was rejected after the pending pairing finished: ", res.StatusCode)
+	}
+}
+
+// UI that hands the responses of pairing requests to the test.
+type pairingUI struct {
+	fakeUI
+	confirm chan chan bool
+}
+
+func (p *pairingUI) Pair(ctx context.Context, code string, res chan bool) {
+	p.confirm <- res
+}
+
+// Makes sure that the result of a pairing can still be received when the first request for it stopped waiting before
+// the user decided.
+func TestPairingResultAfterDisconnect(t *testing.T) {
+	ts, _ := newTestServer(policies.Allow())
+	defer ts.Close()
+	srv := ts.Config.Handler.(*Server)
+	frontend := &pairingUI{confirm: make(chan chan bool, 1)}
+	srv.UI = frontend
+
+	res := postSessions(t, ts, "", "")
+	var pairing PairingResponse
+	err := json.NewDecoder(res.Body).Decode(&pairing)
+	res.Body.Close()
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatal("could not start pairing ", res.StatusCode, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+SessionsPath+pairing.Id, nil)
+	if res, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+		res.Body.Close()
+		t.Fatal("expected the request to stop waiting, got ", res.StatusCode)
+	}
+
+	for waiting := true; waiting; { // Wait until the server noticed that the first request stopped waiting
+		time.Sleep(time.Millisecond)
+		srv.pairings.Lock()
+		waiting = srv.pairings.pending[pairing.Id].waiting
+		srv.pairings.Unlock()
+	}
+
+	(<-frontend.confirm) <- true
+	var session SessionResponse
+	res = postSessions(t, ts, pairing.Id, "")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || json.NewDecoder(res.Body).Decode(&session) != nil || session.Secret == "" {
+		t.Fatal("the confirmed pairing was not received: ", res.StatusCode)
+	}
+}
+
+// Makes sure that action requests without a valid signature are rejected.
+func TestUnauthenticatedRequests(t *testing.T) {
+	ts, frontend := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	now := time.Now()
+	cases := []struct {
+		session sessions.Session
+		sent    time.Time
+	}{
+		{sessions.New(testSession.Id(), "wrongsecret"), now},
+		{sessions.New("unknownsession", testSession.Secret()), now},
+		{testSession, now.Add(-maxClockSkew - time.Minute)},
+		{testSession, now.Add(maxClockSkew + time.Minute)},
+	}
+	for i, c := range cases {
+		if postSigned(t, ts, c.session, c.sent, "nonce"+strconv.Itoa(i)) != http.StatusUnauthorized {
+			t.Error("request with invalid credentials was not rejected: ", c)
+		}
+	}
+
+	res, _ := http.Post(ts.URL+ActionsPath, "application/json", bytes.NewBufferString(`{"plugin":"p","command":{"name":"ls"}}`))
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Error("unsigned request was not rejected")
+	}
+
+	// A request whose body was altered after signing is rejected
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+ActionsPath, bytes.NewBufferString(`{"plugin":"p","command":{"name":"rm"}}`))
+	signRequest(req, testSession, []byte(`{"plugin":"p","command":{"name":"ls"}}`), now, "altered")
+	res, _ = http.DefaultClient.Do(req)
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Error("request with an altered body was not rejected")
+	}
+
+	if frontend.count() != 0 {
+		t.Error("unauthenticated requests should never reach the UI")
+	}
+}
+
+// Makes sure that a signed request can not be replayed.
+func TestReplayedRequest(t *testing.T) {
+	ts, frontend := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	now := time.Now()
+	if postSigned(t, ts, testSession, now, "replayed") != http.StatusOK {
+		t.Fatal("valid request was rejected")
+	}
+	if postSigned(t, ts, testSession, now, "replayed") != http.StatusUnauthorized {
+		t.Error("replayed request was not rejected")
+	}
+	if frontend.count() != 1 {
+		t.Error("replayed request reached the UI")
+	}
+}
+
+func TestPairingCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := generatePairingCode()
+		if err != nil || len(code) != pairingCodeDigits {
+			t.Error("invalid pairing code ", code, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+)
+
+// The approval status of a streamed action.
+type Status string
+
+const (
+	// The action is waiting for a decision
+	StatusQueued Status = "queued"
+	// The action was allowed and will be executed
+	StatusApproved Status = "approved"
+	// The action was denied and will not be executed
+	StatusDenied Status = "denied"
+)
+
+// The event names of the frames in a stream.
+const (
+	// Frame with the approval status of the action
+	EventStatus = "status"
+	// Frame with a chunk of standard output
+	EventStdout = "stdout"
+	// Frame with a chunk of standard error
+	EventStderr = "stderr"
+	// Final frame with the exit code and termination status, or the error if the command could not be executed
+	EventExit = "exit"
+)
+
+// Frame is the JSON data of a single server-sent event in a stream.
+type Frame struct {
+	// The id that the hook assigned to the request, this allows the plugin to cancel the action
+	Id string `json:"id"`
+	// The hash of the requested action, this allows the plugin to correlate frames
+	Hash string `json:"hash"`
+	// The approval status, only set for status frames
+	Status Status `json:"status,omitempty"`
+	// The Id of the applied policy, only set for approved and denied status frames
+	Policy string `json:"policy,omitempty"`
+	// A chunk of output, only set for stdout and stderr frames
+	Data string `json:"data,omitempty"`
+	// The exit code, only set for exit frames
+	ExitCode *int `json:"exitCode,omitempty"`
+	// How the command terminated (eg. timeout), only set for exit frames
+	Termination executor.Termination `json:"termination,omitempty"`
+	// The signal that killed the command, only set for exit frames
+	Signal string `json:"signal,omitempty"`
+	// Set on the exit frame if the command could not be executed
+	Error string `json:"error,omitempty"`
+}
+
+// Writes frames as server-sent events and flushes them to the client immediately.
+// It is safe for concurrent use as stdout and stderr are written from different goroutines.
+type frameWriter struct {
+	sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	id      string
+	hash    string
+}
+
+// Writes a single frame of the given event type.
+func (f *frameWriter) write(event string, frame Frame) {
+	f.Lock()
+	defer f.Unlock()
+	frame.Id, frame.Hash = f.id, f.hash
+	data, err := json.Marshal(frame)
+	if err != nil {
+		panic(err) // Frames only contain strings and ints
+	}
+	fmt.Fprintf(f.w, "event: %s\ndata: %s\n\n", event, data)
+	f.flusher.Flush()
+}
+
+// Returns an io.Writer that writes every chunk as a frame of the given event type.
+func (f *frameWriter) output(event string) *outputWriter {
+	return &outputWriter{frames: f, event: event}
+}
+
+// Writes output chunks as frames. A character that is split across chunks is sent in the frame of the chunk it ends in,
+// as a frame only contains complete UTF-8 characters.
+type outputWriter struct {
+	frames *frameWriter
+	event  string
+	// The start of an incomplete character at the end of the last chunk
+	pending []byte
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	data := append(o.pending, p...)
+	n := len(data)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	o.pending = append([]byte(nil), data[n:]...)
+	if n > 0 {
+		o.frames.write(o.event, Frame{Data: string(data[:n])})
+	}
+	return len(p), nil
+}
+
+// Sends the incomplete character that is left at the end of the output, it is replaced by U+FFFD in the frame.
+func (o *outputWriter) Close() error {
+	if len(o.pending) > 0 {
+		o.frames.write(o.event, Frame{Data: string(o.pending)})
+		o.pending = nil
+	}
+	return nil
+}
+
+// Handles a JSON encoded actions.Action and streams the approval status, output and exit code as server-sent events.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	req, ok := s.readAction(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	frames := &frameWriter{w: w, flusher: flusher, id: req.Id, hash: req.Action.Hash()}
+	ctx, done := s.track(r.Context(), req)
+	defer done()
+
+	frames.write(EventStatus, Frame{Status: StatusQueued})
+	d, err := s.decide(ctx, &req)
+	if err == errAuditFailed {
+		frames.write(EventExit, Frame{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		if r.Context().Err() == nil { // Cancelled while the client is still waiting
+			frames.write(EventExit, Frame{Termination: executor.Cancelled, Error: errCancelled.Error()})
+		}
+		return
+	}
+	if !d.Policy.Allows() {
+		frames.write(EventStatus, Frame{Status: StatusDenied, Policy: d.Policy.Id()})
+		return
+	}
+	frames.write(EventStatus, Frame{Status: StatusApproved, Policy: d.Policy.Id()})
+
+	execReq := s.start(req)
+	stdout, stderr := frames.output(EventStdout), frames.output(EventStderr)
+	execReq.Stdout, execReq.Stderr = stdout, stderr
+	res, err := s.executorFor(req).Execute(ctx, execReq)
+	stdout.Close()
+	stderr.Close()
+	s.auditExecution(req, res, err)
+	if err != nil {
+		frames.write(EventExit, Frame{Error: err.Error()})
+		return
+	}
+	frames.write(EventExit, Frame{ExitCode: &res.ExitCode, Termination: res.Status, Signal: res.Signal})
+}
@@ -0,0 +1,158 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A decoded server-sent event.
+type event struct {
+	name  string
+	frame Frame
+}
+
+// Posts the action to the stream endpoint and reads all events until the stream is closed.
+func streamAction(t *testing.T, ts *httptest.Server, a actions.Action) []event {
+	body, _ := json.Marshal(a)
+	res, err := http.DefaultClient.Do(newAuthenticatedRequest(http.MethodPost, ts.URL+StreamPath, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("unexpected stream response ", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	var events []event
+	var current event
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.frame); err != nil {
+				t.Fatal(err)
+			}
+		case line == "":
+			events = append(events, current)
+			current = event{}
+		}
+	}
+	return events
+}
+
+// Makes sure that an allowed action streams its status, output and exit code.
+func TestStreamAllowed(t *testing.T) {
+	ts, _ := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	events := streamAction(t, ts, testAction)
+	var stdout, stderr string
+	for _, e := range events {
+		if e.frame.Hash != testAction.Hash() {
+			t.Error("frame does not carry the action hash")
+		}
+		switch e.name {
+		case EventStdout:
+			stdout += e.frame.Data
+		case EventStderr:
+			stderr += e.frame.Data
+		}
+	}
+	if len(events) < 4 || events[0].frame.Status != StatusQueued || events[1].frame.Status != StatusApproved || events[1].frame.Policy != policies.Allow().Id() {
+		t.Fatal("unexpected status frames: ", events)
+	}
+	last := events[len(events)-1]
+	if last.name != EventExit || last.frame.ExitCode == nil || *last.frame.ExitCode != 1 {
+		t.Error("the last frame should be the exit frame: ", last)
+	}
+	if stdout != "git status" || stderr != "err" {
+		t.Error("output was not streamed: ", stdout, stderr)
+	}
+}
+
+// Makes sure that a denied action only streams its status.
+func TestStreamDenied(t *testing.T) {
+	ts, _, exec := newExecutingTestServer(policies.Deny())
+	defer ts.Close()
+
+	events := streamAction(t, ts, testAction)
+	if len(events) != 2 || events[0].frame.Status != StatusQueued || events[1].frame.Status != StatusDenied {
+		t.Error("unexpected frames for a denied action: ", events)
+	}
+	if exec.count() != 0 {
+		t.Error("denied action was executed")
+	}
+}
+
+// Makes sure that the exit frame reports when a command could not be executed.
+func TestStreamExecutionError(t *testing.T) {
+	ts, _ := newTestServer(policies.Allow())
+	defer ts.Close()
+
+	events := streamAction(t, ts, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "fail"}})
+	last := events[len(events)-1]
+	if last.name != EventExit || last.frame.Error == "" || last.frame.ExitCode != nil {
+		t.Error("the exit frame should contain the execution error: ", last)
+	}
+}
+
+// Makes sure that a character that is written in two chunks is sent complete in a single frame, and that an incomplete
+// character at the end of the output is still sent when the output is closed.
+func TestStreamSplitCharacter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	out := (&frameWriter{w: rec, flusher: rec}).output(EventStdout)
+	for _, chunk := range []string{"caf\xc3", "\xa9!", "\xc3"} {
+		if n, err := out.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatal("unexpected write result ", n, err)
+		}
+	}
+	out.Close()
+
+	var data []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			var frame Frame
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame); err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, frame.Data)
+		}
+	}
+	expected := []string{"caf", "é!", "�"}
+	if strings.Join(data, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected the frames %q, got %q", expected, data)
+	}
+}
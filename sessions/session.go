@@ -24,6 +24,11 @@
 
 package sessions
 
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
 // A session reflects an authenticated backstage user. Note that all authenticated users share the same namespace for now.
 // That means that all sessions execute on the same executor and share the same authorized policies (eg. allow always).
 type Session interface {
@@ -32,6 +37,9 @@ type Session interface {
 
 	// The secret that verifies authenticity of this session
 	Secret() string
+
+	// Checks whether signature is the signature of the request made with the secret of this session (see Sign)
+	Verify(signature string, method string, path string, body []byte, timestamp string, nonce string) bool
 }
 
 // Creates a new session instance with the id and secret.
@@ -51,3 +59,25 @@ func (s *session) Id() string {
 func (s *session) Secret() string {
 	return s.secret
 }
+
+// Generates a new session with a random id and secret.
+func Generate() (Session, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	return New(id, secret), nil
+}
+
+// Returns n random bytes from a cryptographically secure source, encoded as url safe base64.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -38,3 +38,18 @@ func TestSessionConstructor(t *testing.T) {
 		}
 	}
 }
+
+// Makes sure that generated sessions are unique and have a strong secret.
+func TestGenerate(t *testing.T) {
+	s1, err1 := Generate()
+	s2, err2 := Generate()
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+	if s1.Id() == s2.Id() || s1.Secret() == s2.Secret() {
+		t.Error("generated sessions are not unique")
+	}
+	if len(s1.Secret()) < 32 || s1.Id() == "" {
+		t.Error("generated session secret is too short")
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package sessions
+
+import "testing"
+
+// Makes sure that a signature only verifies if every signed part of the request is unchanged.
+func TestSignature(t *testing.T) {
+	s := New("id", "secret")
+	body := []byte(`{"command":{"name":"ls"}}`)
+	signature := Sign("secret", "POST", "/actions", body, "1600000000", "nonce")
+
+	if !s.Verify(signature, "POST", "/actions", body, "1600000000", "nonce") {
+		t.Error("valid signature was rejected")
+	}
+	altered := []struct {
+		secret, method, path, body, timestamp, nonce string
+	}{
+		{"other", "POST", "/actions", string(body), "1600000000", "nonce"},
+		{"secret", "GET", "/actions", string(body), "1600000000", "nonce"},
+		{"secret", "POST", "/actions/stream", string(body), "1600000000", "nonce"},
+		{"secret", "POST", "/actions", `{"command":{"name":"rm"}}`, "1600000000", "nonce"},
+		{"secret", "POST", "/actions", string(body), "1600000001", "nonce"},
+		{"secret", "POST", "/actions", string(body), "1600000000", "other"},
+	}
+	for _, a := range altered {
+		if New("id", a.secret).Verify(signature, a.method, a.path, []byte(a.body), a.timestamp, a.nonce) {
+			t.Error("signature verified for an altered request: ", a)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/audit"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/config"
+	"github.com/tcorp-bv/backstage-hook/executor"
+	"github.com/tcorp-bv/backstage-hook/server"
+	"github.com/tcorp-bv/backstage-hook/storage"
+	"github.com/tcorp-bv/backstage-hook/ui"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// Time between prunes of expired policies from the storage
+	sweepInterval = time.Minute
+	// The time the hook waits for the responses to the requests that were denied or killed when it shuts down
+	shutdownTimeout = 10 * time.Second
+)
+
+// Starts the hook: backstage-hook start [flags] [backstage-url]
+var startCommand = &cli.Command{
+	Name:    "start",
+	Usage:   "Start accepting actions from the Backstage instance at backstage-url (default: backstage in the configuration)",
+	Flags:   config.CliFlags(),
+	Args:    []cli.Arg{{Name: "backstage-url", Optional: true}},
+	Handler: start,
+}
+
+func start(c *cli.Context) error {
+	a := c.App
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if backstageURL := c.Arg("backstage-url"); backstageURL != "" {
+		if err := cfg.Set("backstage", backstageURL, "argument"); err != nil {
+			return err
+		}
+	}
+	if cfg.Backstage == "" {
+		return fmt.Errorf("missing the url of your Backstage instance, pass it as argument or set backstage in %s", cfg.File)
+	}
+	origin, err := parseOrigin(cfg.Backstage)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	if cfg.PolicyFile != "" {
+		if err := loadPolicyFile(store, cfg.PolicyFile); err != nil {
+			return err
+		}
+	}
+	go storage.Sweep(context.Background(), store, sweepInterval)
+	path, err := auditPath(cfg)
+	if err != nil {
+		return err
+	}
+	log, err := audit.Open(path)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	var frontend ui.UI
+	switch cfg.UI {
+	case "cli":
+		frontend = ui.NewCli(a)
+	case "web":
+		base, err := webURL(cfg.Listen)
+		if err != nil {
+			return err
+		}
+		web, err := ui.NewWeb(a, base)
+		if err != nil {
+			return err
+		}
+		frontend = web
+	}
+	frontend.Setup()
+	defer frontend.Close()
+
+	srv := server.New(store, frontend, executor.New(), origin)
+	srv.Audit = log
+	srv.Timeout = cfg.Timeout
+	srv.PassEnv = commandEnv(cfg)
+	srv.Limits = cfg.Limits
+	srv.Executors[executor.Sandbox] = executor.NewSandbox()
+	var handler http.Handler = srv
+	if web, ok := frontend.(ui.WebUI); ok { // The web UI is not meant for Backstage and is served outside of its origin check
+		web.SetExecutions(srv)
+		mux := http.NewServeMux()
+		mux.Handle(ui.WebPath, web)
+		mux.Handle("/", srv)
+		handler = mux
+	}
+	httpServer := &http.Server{Addr: cfg.Listen, Handler: handler}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		<-interrupted
+		signal.Stop(interrupted) // A second interrupt exits right away
+		shutdown(httpServer, frontend, srv)
+	}()
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// Shuts the hook down: the pending requests are denied, the running commands are killed and the responses are sent
+// before the server stops, unless that takes longer than shutdownTimeout.
+func shutdown(httpServer *http.Server, frontend ui.UI, srv *server.Server) {
+	frontend.Close()
+	for _, e := range srv.Running() {
+		srv.Cancel(e.Id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	_ = httpServer.Shutdown(ctx)
+}
+
+// Opens the file storage in dir, or in the default storage directory if dir is empty.
+func openStore(dir string) (storage.Store, error) {
+	if dir == "" {
+		var err error
+		if dir, err = storage.DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	pol, err := storage.NewFilePolicyStorage(filepath.Join(dir, storage.PoliciesFile))
+	if err != nil {
+		return nil, err
+	}
+	ses, err := storage.NewFileSessionStorage(filepath.Join(dir, storage.SessionsFile))
+	if err != nil {
+		return nil, err
+	}
+	return storage.New(pol, ses), nil
+}
+
+// Returns the names of the variables that are passed to every command: the configured base environment (minimal or
+// empty) followed by the configured names.
+func commandEnv(cfg *config.Config) []string {
+	var names []string
+	if cfg.Env == "minimal" {
+		names = append(names, executor.MinimalEnv...)
+	}
+	return append(names, cfg.PassEnv...)
+}
+
+// Returns the url that the web UI is served on when the hook listens on the address listen. If it listens on all
+// addresses (eg. :7077, 0.0.0.0:7077 or [::]:7077), the url points to the loopback address.
+func webURL(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %v", listen, err)
+	}
+	switch ip := net.ParseIP(host); {
+	case host == "" || ip.Equal(net.IPv4zero):
+		host = "127.0.0.1"
+	case ip.Equal(net.IPv6unspecified):
+		host = "::1"
+	}
+	return "http://" + net.JoinHostPort(host, port), nil
+}
+
+// Parses the Backstage url into an origin (scheme://host[:port]) as sent by browsers.
+func parseOrigin(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not a valid Backstage url, expected eg. http://localhost:3000", rawurl)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
@@ -26,9 +26,13 @@ package storage
 
 /*
 	The storage package manages all persistent data for the backstage Handler.
-    Currently this data consists of policies (eg. ALLOW_ALWAYS) and sessions. A
-    policy is identified by an Action (That is the combination of the command,
-    its arguments and the plugin). A session is identified by its Id.
+    Currently this data consists of policies (eg. ALLOW_ALWAYS), rules and
+    sessions. A policy is identified by an Action (That is the combination of
+    the command, its arguments and the plugin). A policy that allows an
+    action only applies to the executable it was set for, so replacing the
+    binary of a command asks the user again. A rule matches many actions
+    through patterns (see the rules package) and is identified by its Id. A
+    session is identified by its Id.
 */
 
 /*
@@ -38,6 +42,11 @@ package storage
     storage. To get started, you must call New(...) with the implementations for
     these you wish to use, the returned object allows you to get and set the
     relevant data.
+
+    Two backends are provided: an in-memory backend for testing
+    (NewMemoryPolicyStorage, NewMemorySessionStorage) and a JSON file backend
+    that persists across restarts and can be shared by multiple processes
+    (NewFilePolicyStorage, NewFileSessionStorage).
 */
 
 /*
@@ -50,4 +59,7 @@ package storage
     The storage package contains some other interfaces (Store, PoliciesStore...)
     but these are meant for external access and provide the external interface.
     You should thus not re-implement these.
+
+    The Nonces interface in nonces.go is used directly to reject replayed
+    requests, it does not need to persist across restarts.
 */
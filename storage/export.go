@@ -0,0 +1,128 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"time"
+)
+
+// The version of the export format, it changes whenever an export can not be read by an older hook.
+const ExportVersion = 1
+
+// Returned when an export has a version that this hook does not support.
+var errExportVersion = fmt.Errorf("unsupported export version, expected %d", ExportVersion)
+
+// Decisions that are exported to move them to another machine. The actions are exported instead of their hashes, so
+// an export does not depend on the hash format.
+type Export struct {
+	// The version of the format, see ExportVersion
+	Version int `json:"version"`
+	// The stored policies
+	Policies []ExportedPolicy `json:"policies"`
+	// The stored rules
+	Rules []rules.Rule `json:"rules"`
+}
+
+// A policy stored for an exact action.
+type ExportedPolicy struct {
+	// The action the policy applies to
+	Action actions.Action `json:"action"`
+	// The Id of the policy (eg. ALLOW_ALWAYS)
+	Policy string `json:"policy"`
+	// The time the policy was set
+	Set time.Time `json:"set"`
+}
+
+// Exports the policies and rules of the store. Policies that expire are not exported, they only apply on this machine
+// for a while. Neither are policies that were stored before actions were recorded, as their action is unknown. The
+// executables that the approvals and rules are pinned to are not exported, see portable.
+func ExportPolicies(s PoliciesStore) Export {
+	e := Export{Version: ExportVersion, Policies: []ExportedPolicy{}, Rules: portableRules(s.Rules())}
+	for _, entry := range s.Policies() {
+		if entry.Action == nil || entry.Policy.Duration() > 0 || entry.Policy.UntilRestart() {
+			continue
+		}
+		exported := ExportedPolicy{Action: portable(*entry.Action), Policy: entry.Policy.Id(), Set: entry.Set}
+		e.Policies = append(e.Policies, exported)
+	}
+	return e
+}
+
+// Returns the rules without the executables they are pinned to, see portable. Unlike approvals, imported rules are not
+// pinned again: they allow any executable of their command.
+func portableRules(rs []rules.Rule) []rules.Rule {
+	portable := make([]rules.Rule, len(rs))
+	for i, r := range rs {
+		r.Executable = nil
+		portable[i] = r
+	}
+	return portable
+}
+
+// Returns the action without the executable and the passed variables, which are resolved on every machine. An imported
+// approval is pinned to the executable it is first used with, as the binary on another machine has another digest.
+func portable(a actions.Action) actions.Action {
+	a.Executable, a.PassEnv = nil, nil
+	return a
+}
+
+// Adds the policies and rules of the export to the store, replacing policies for the same actions and rules with the
+// same ids. Nothing is imported if the export is invalid.
+func ImportPolicies(s PoliciesStore, e Export) error {
+	if e.Version != ExportVersion {
+		return errExportVersion
+	}
+	pols := make([]policies.Policy, len(e.Policies))
+	for i, p := range e.Policies {
+		pol, err := policies.ById(p.Policy)
+		if err != nil {
+			return fmt.Errorf("policy %d: unknown policy %q", i+1, p.Policy)
+		}
+		if pol.Duration() > 0 || pol.UntilRestart() {
+			return fmt.Errorf("policy %d: %s expires and can not be imported", i+1, p.Policy)
+		}
+		if err := p.Action.Command.Validate(); err != nil {
+			return fmt.Errorf("policy %d: %v", i+1, err)
+		}
+		pols[i] = pol
+	}
+	for _, r := range e.Rules {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %s: %v", r.Id, err)
+		}
+	}
+
+	for i, p := range e.Policies {
+		s.SetPolicy(portable(p.Action), pols[i]) // Exports of older versions contain the executable
+	}
+	for _, r := range portableRules(e.Rules) {
+		_ = s.AddRule(r) // Validated above
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"testing"
+)
+
+// Makes sure that decisions survive an export and import through JSON.
+func TestExportImport(t *testing.T) {
+	var acts []actions.Action
+	for _, name := range []string{"ls", "rm", "cat", "git"} {
+		acts = append(acts, actions.Action{Plugin: "test", Command: actions.Command{Name: name, Args: []string{"status"}}})
+	}
+	from := New(NewMemoryPolicyStorage(), nil)
+	from.SetPolicy(acts[0], policies.AllowAlways())
+	from.SetPolicy(acts[1], policies.DenyAlways())
+	from.SetPolicy(acts[2], policies.AllowFor15Minutes())
+	rule := rules.FromAction(acts[3], rules.Allow)
+	_ = from.AddRule(rule)
+
+	data, err := json.Marshal(ExportPolicies(from))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Export
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Policies) != 2 {
+		t.Error("expected the 2 policies that do not expire to be exported, got ", e.Policies)
+	}
+
+	to := New(NewMemoryPolicyStorage(), nil)
+	if err := ImportPolicies(to, e); err != nil {
+		t.Fatal(err)
+	}
+	assertPolicyValue(t, to, acts[0], policies.AllowAlways())
+	assertPolicyValue(t, to, acts[1], policies.DenyAlways())
+	assertPolicyNotExist(t, to, acts[2])
+	if rs := to.Rules(); len(rs) != 1 || rs[0].Id != rule.Id {
+		t.Error("rules were not imported: ", rs)
+	}
+}
+
+// Makes sure that an imported approval applies on a machine where the executable has another digest, and is pinned to
+// the first executable it is used with there.
+func TestExportImportExecutable(t *testing.T) {
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "git", Args: []string{"status"}}}
+	a.Executable = &actions.Executable{Path: "/usr/bin/git", Digest: "1234"}
+	a.PassEnv = []string{"HOME"}
+	from := New(NewMemoryPolicyStorage(), nil)
+	from.SetPolicy(a, policies.AllowAlways())
+	similar := a
+	similar.Command.Name = "make"
+	_ = from.AddRule(rules.FromAction(similar, rules.Allow))
+
+	data, err := json.Marshal(ExportPolicies(from))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Export
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Policies) != 1 || e.Policies[0].Action.Executable != nil || e.Policies[0].Action.PassEnv != nil {
+		t.Error("the executable of this machine was exported: ", e.Policies)
+	}
+	if len(e.Rules) != 1 || e.Rules[0].Executable != nil {
+		t.Error("the executable of a rule was exported: ", e.Rules)
+	}
+	e.Policies[0].Action.Executable = a.Executable // As exported by an older version
+	to := New(NewMemoryPolicyStorage(), nil)
+	if err := ImportPolicies(to, e); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Executable = &actions.Executable{Path: "/usr/local/bin/git", Digest: "5678"}
+	assertPolicyValue(t, to, a, policies.AllowAlways())
+	a.Executable = &actions.Executable{Path: "/tmp/git", Digest: "9abc"}
+	assertPolicyNotExist(t, to, a)
+	similar.Executable = a.Executable
+	if _, ok := to.Match(similar); !ok {
+		t.Error("imported rule does not apply on this machine")
+	}
+}
+
+// Makes sure that nothing is imported from an invalid export.
+func TestImportInvalid(t *testing.T) {
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "ls"}}
+	valid := ExportedPolicy{Action: a, Policy: policies.AllowAlways().Id()}
+	exports := []Export{
+		{Version: ExportVersion + 1},
+		{Version: ExportVersion, Policies: []ExportedPolicy{valid, {Action: a, Policy: "UNKNOWN"}}},
+		{Version: ExportVersion, Policies: []ExportedPolicy{valid, {Action: a, Policy: policies.AllowFor15Minutes().Id()}}},
+		{Version: ExportVersion, Policies: []ExportedPolicy{valid, {Policy: policies.AllowAlways().Id()}}},
+		{Version: ExportVersion, Policies: []ExportedPolicy{valid}, Rules: []rules.Rule{{Id: "invalid"}}},
+	}
+	for _, e := range exports {
+		s := New(NewMemoryPolicyStorage(), nil)
+		if ImportPolicies(s, e) == nil {
+			t.Error("invalid export was imported: ", e)
+		}
+		if len(s.Policies()) != 0 || len(s.Rules()) != 0 {
+			t.Error("invalid export was partially imported: ", e)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// The name of the directory in the user's config directory in which the hook stores its data
+	configDirName = "backstage-hook"
+	// The file name of the policy storage in the storage directory
+	PoliciesFile = "policies.json"
+	// The file name of the session storage in the storage directory
+	SessionsFile = "sessions.json"
+)
+
+// Returns the default storage directory in the user's config directory, eg. ~/.config/backstage-hook on Linux.
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configDirName), nil
+}
+
+// The contents of the policy storage file.
+type policiesFile struct {
+	Policies map[string]StoredPolicy `json:"policies"`
+	Rules    []rules.Rule            `json:"rules"`
+}
+
+// The contents of the session storage file.
+type sessionsFile struct {
+	Sessions map[string]StoredSession `json:"sessions"`
+}
+
+// Policies storage implementation that persists all data to a JSON file.
+type filePolicyStorage struct {
+	file *jsonFile
+}
+
+// Sessions storage implementation that persists all data to a JSON file.
+type fileSessionStorage struct {
+	file *jsonFile
+}
+
+func (f *filePolicyStorage) Store(key string, value StoredPolicy) {
+	var contents policiesFile
+	f.file.updateOrLog(&contents, func() {
+		if contents.Policies == nil {
+			contents.Policies = map[string]StoredPolicy{}
+		}
+		if (value == StoredPolicy{}) { // In case the StoredPolicy is empty, the policy should be deleted.
+			delete(contents.Policies, key)
+			return
+		}
+		contents.Policies[key] = value
+	})
+}
+
+func (f *filePolicyStorage) Get(key string) (StoredPolicy, bool) {
+	var contents policiesFile
+	f.file.read(&contents)
+	value, ok := contents.Policies[key]
+	return value, ok
+}
+
+func (f *filePolicyStorage) Update(key string, update func(val StoredPolicy, ok bool) (StoredPolicy, bool)) error {
+	var contents policiesFile
+	return f.file.update(&contents, func() {
+		value, ok := contents.Policies[key]
+		value, changed := update(value, ok)
+		switch {
+		case !changed:
+		case value == StoredPolicy{}:
+			delete(contents.Policies, key)
+		default:
+			if contents.Policies == nil {
+				contents.Policies = map[string]StoredPolicy{}
+			}
+			contents.Policies[key] = value
+		}
+	})
+}
+
+func (f *filePolicyStorage) Range(fn func(key string, val StoredPolicy) bool) {
+	var contents policiesFile
+	f.file.read(&contents)
+	for key, val := range contents.Policies {
+		if !fn(key, val) {
+			return
+		}
+	}
+}
+
+func (f *filePolicyStorage) UpdateRules(update func(rs []rules.Rule) []rules.Rule) error {
+	var contents policiesFile
+	return f.file.update(&contents, func() {
+		contents.Rules = update(contents.Rules)
+	})
+}
+
+func (f *filePolicyStorage) Rules() []rules.Rule {
+	var contents policiesFile
+	f.file.read(&contents)
+	return contents.Rules
+}
+
+func (f *filePolicyStorage) Prune(expired func(key string, val StoredPolicy) bool) int {
+	var contents policiesFile
+	pruned := 0
+	f.file.updateOrLog(&contents, func() {
+		for key, val := range contents.Policies {
+			if expired(key, val) {
+				delete(contents.Policies, key)
+				pruned++
+			}
+		}
+	})
+	return pruned
+}
+
+func (f *fileSessionStorage) Store(key string, value StoredSession) {
+	var contents sessionsFile
+	f.file.updateOrLog(&contents, func() {
+		if contents.Sessions == nil {
+			contents.Sessions = map[string]StoredSession{}
+		}
+		if (value == StoredSession{}) { // In case the StoredSession is empty, the session should be deleted.
+			delete(contents.Sessions, key)
+			return
+		}
+		contents.Sessions[key] = value
+	})
+}
+
+func (f *fileSessionStorage) Get(key string) (StoredSession, bool) {
+	var contents sessionsFile
+	f.file.read(&contents)
+	value, ok := contents.Sessions[key]
+	return value, ok
+}
+
+func (f *fileSessionStorage) Range(fn func(key string, value StoredSession) bool) {
+	var contents sessionsFile
+	f.file.read(&contents)
+	for key, val := range contents.Sessions {
+		if !fn(key, val) {
+			return
+		}
+	}
+}
+
+// Returns a Policies implementation that persists to the JSON file at path. The file and its directory are created
+// if they do not exist. Multiple processes can safely use the same file.
+func NewFilePolicyStorage(path string) (Policies, error) {
+	file, err := openJSONFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &filePolicyStorage{file: file}, nil
+}
+
+// Returns a Sessions implementation that persists to the JSON file at path. The file and its directory are created
+// if they do not exist. Multiple processes can safely use the same file.
+func NewFileSessionStorage(path string) (Sessions, error) {
+	file, err := openJSONFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSessionStorage{file: file}, nil
+}
+
+// A JSON encoded file that is shared between processes. Every read happens under a shared lock and every update
+// happens under an exclusive lock, updates are written to a temporary file which then atomically replaces the file.
+type jsonFile struct {
+	path string
+	lock *fileLock
+}
+
+// Creates the directory of the file and checks that the file is readable.
+func openJSONFile(path string) (*jsonFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f := &jsonFile{path: path, lock: &fileLock{path: path + ".lock"}}
+	h, err := f.lock.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer f.lock.unlock(h)
+	var contents json.RawMessage
+	return f, f.decode(&contents)
+}
+
+// Reads the contents of the file into v. The storage interfaces have no way to report errors: if the file can not be
+// read, v is left empty so that every decision is asked again.
+func (f *jsonFile) read(v interface{}) {
+	h, err := f.lock.lock(false)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.lock.unlock(h)
+	if err := f.decode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// Reads the contents of the file into v, calls modify to change v and writes v back to the file. The file is locked
+// exclusively the whole time, so no update is lost.
+func (f *jsonFile) update(v interface{}, modify func()) error {
+	h, err := f.lock.lock(true)
+	if err != nil {
+		return err
+	}
+	defer f.lock.unlock(h)
+	if err := f.decode(v); err != nil {
+		return err // Never overwrite a file we could not read
+	}
+	modify()
+	return f.write(v)
+}
+
+// Like update, for the storage interfaces that have no way to report errors.
+func (f *jsonFile) updateOrLog(v interface{}, modify func()) {
+	if err := f.update(v, modify); err != nil {
+		log.Println(err)
+	}
+}
+
+// Decodes the file into v, a nonexistent file is decoded as empty.
+func (f *jsonFile) decode(v interface{}) error {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Atomically replaces the file with the JSON encoding of v.
+func (f *jsonFile) write(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly after a successful rename
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,223 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"github.com/tcorp-bv/backstage-hook/sessions"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Creates a temporary storage directory, the returned function removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "backstage-hook-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// Opens the file backends in dir.
+func openFileStore(t *testing.T, dir string) Store {
+	pol, err := NewFilePolicyStorage(filepath.Join(dir, PoliciesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ses, err := NewFileSessionStorage(filepath.Join(dir, SessionsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(pol, ses)
+}
+
+func TestFileStorage(t *testing.T) {
+	// Runs the integration tests of the memory storage against the file storage
+	dir, remove := tempDir(t)
+	defer remove()
+	s := openFileStore(t, dir)
+	testPolicyStorage(t, s)
+	testRuleStorage(t, s)
+	testPinnedStorage(t, s)
+	testSessionStorage(t, s)
+
+	pol, err := NewFilePolicyStorage(filepath.Join(dir, PoliciesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testExpiringStorage(t, pol)
+}
+
+// Makes sure that data survives a restart.
+func TestFileStoragePersists(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	act := actions.Action{Plugin: "test", Command: actions.Command{Name: "git", Args: []string{"status"}}}
+	rule := rules.Rule{Id: "ls", Command: "ls", Args: []rules.Pattern{rules.AnyArgs}, Decision: rules.Allow}
+	s := openFileStore(t, dir)
+	s.SetPolicy(act, policies.AllowAlways())
+	s.SetSession(sessions.New("id", "secret"))
+	if err := s.AddRule(rule); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openFileStore(t, dir)
+	assertPolicyValue(t, s, act, policies.AllowAlways())
+	assertSessionValue(t, s, sessions.New("id", "secret"))
+	if rs := s.Rules(); len(rs) != 1 || rs[0].Id != rule.Id {
+		t.Error("rules did not persist")
+	}
+	info, err := os.Stat(filepath.Join(dir, PoliciesFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Error("storage file should only be accessible by the user")
+	}
+}
+
+// Makes sure that concurrent writers using separate instances do not lose each other's updates.
+func TestFileStorageConcurrentWriters(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(s Sessions, writer int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s.Store(string(rune('a'+writer))+string(rune('a'+j)), StoredSession{Secret: "secret", Created: time.Now()})
+			}
+		}(openFileStore(t, dir).(*store).SessionStore, i)
+	}
+	wg.Wait()
+
+	var stores []PoliciesStore
+	for i := 0; i < 4; i++ {
+		stores = append(stores, openFileStore(t, dir))
+	}
+	testConcurrentRules(t, stores)
+
+	s := openFileStore(t, dir).(*store).SessionStore
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			if _, ok := s.Get(string(rune('a'+i)) + string(rune('a'+j))); !ok {
+				t.Fatal("an update of a concurrent writer was lost")
+			}
+		}
+	}
+}
+
+// Makes sure that readers and writers sharing one instance each hold their own lock, run this with -race.
+func TestFileStorageSharedInstance(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	pol, err := NewFilePolicyStorage(filepath.Join(dir, PoliciesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				pol.Get("key")
+				pol.Rules()
+				pol.Store(string(rune('a'+writer))+string(rune('a'+j)), StoredPolicy{PolicyId: policies.Allow().Id(), Timestamp: time.Now()})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	pol.Range(func(key string, val StoredPolicy) bool {
+		count++
+		return true
+	})
+	if count != 4*20 {
+		t.Error("expected 80 policies, got ", count)
+	}
+}
+
+// Makes sure that the policies a running hook keeps until it restarts are listed and revoked by another process.
+func TestFileStorageListsUntilRestart(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	hook, cli := openFileStore(t, dir), openFileStore(t, dir)
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "git", Args: []string{"status"}}}
+	hook.SetPolicy(a, policies.AllowUntilRestart())
+
+	entries := cli.Policies()
+	if len(entries) != 1 || entries[0].Key != a.Hash() || entries[0].Policy != policies.AllowUntilRestart() {
+		t.Fatal("policy of the running hook is not listed: ", entries)
+	}
+	if !cli.RevokePolicy(entries[0].Key) {
+		t.Fatal("policy of the running hook could not be revoked")
+	}
+	if _, ok := hook.Policy(a); ok {
+		t.Error("revoked policy still applies in the running hook")
+	}
+}
+
+// Makes sure that a second hook that shares the storage does not prune the policies the first one keeps until it
+// restarts, and does not apply them either.
+func TestFileStorageKeepsUntilRestart(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	first, second := openFileStore(t, dir), openFileStore(t, dir)
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "git", Args: []string{"status"}}}
+	first.SetPolicy(a, policies.AllowUntilRestart())
+
+	if pruned := second.PruneExpired(); pruned != 0 {
+		t.Error("expected no policies to be pruned, got ", pruned)
+	}
+	if _, ok := second.Policy(a); ok {
+		t.Error("policy of the other hook applies")
+	}
+	if p, ok := first.Policy(a); !ok || p != policies.AllowUntilRestart() {
+		t.Error("policy of the running hook was pruned by the other hook")
+	}
+}
+
+// Makes sure that a corrupt file is reported when opening the storage.
+func TestFileStorageCorrupt(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	path := filepath.Join(dir, PoliciesFile)
+	if err := ioutil.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFilePolicyStorage(path); err == nil {
+		t.Error("opening a corrupt storage file should fail")
+	}
+}
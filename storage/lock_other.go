@@ -0,0 +1,72 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// Time between attempts to acquire the lock
+	lockRetryInterval = 10 * time.Millisecond
+	// A lock file older than this was left behind by a crashed process and is removed
+	staleLockAge = 10 * time.Second
+)
+
+// A lock on a file that is shared between processes. On platforms without flock(2) the lock is held by
+// exclusively creating a lock file, shared locks are therefore exclusive as well.
+type fileLock struct {
+	path string
+}
+
+// A held lock, the lock file itself is the lock so there is nothing to hold on to.
+type lockHandle struct{}
+
+// Blocks until the lock is acquired, the returned handle must be passed to unlock.
+func (l *fileLock) lock(exclusive bool) (lockHandle, error) {
+	for {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			return lockHandle{}, f.Close()
+		}
+		if !os.IsExist(err) {
+			return lockHandle{}, err
+		}
+		if info, err := os.Stat(l.path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			_ = os.Remove(l.path)
+			continue
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Releases the lock that h holds.
+func (l *fileLock) unlock(h lockHandle) {
+	_ = os.Remove(l.path)
+}
@@ -24,7 +24,11 @@
 
 package storage
 
-import "sync"
+import (
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"sync"
+	"time"
+)
 
 // Simple in-memory Policies storage implementation.
 type memoryPolicyStorage struct {
@@ -32,6 +36,8 @@ type memoryPolicyStorage struct {
 	sync.Mutex
 	// The map where all the policies are stored
 	storage map[string]StoredPolicy
+	// The stored rules
+	rules []rules.Rule
 }
 
 // Simple in-memory Sessions storage implementation.
@@ -42,6 +48,14 @@ type memorySessionStorage struct {
 	storage map[string]StoredSession
 }
 
+// Simple in-memory Nonces storage implementation.
+type memoryNonceStorage struct {
+	// Ensures that multi-threaded access is synchronized through locking and unlocking the storage
+	sync.Mutex
+	// The used nonces and when they expire
+	storage map[string]time.Time
+}
+
 func (m *memoryPolicyStorage) Store(key string, value StoredPolicy) {
 	m.Lock()
 	defer m.Unlock()
@@ -58,6 +72,61 @@ func (m *memoryPolicyStorage) Get(key string) (StoredPolicy, bool) {
 	return value, ok
 }
 
+func (m *memoryPolicyStorage) Update(key string, update func(val StoredPolicy, ok bool) (StoredPolicy, bool)) error {
+	m.Lock()
+	defer m.Unlock()
+	value, ok := m.storage[key]
+	value, changed := update(value, ok)
+	switch {
+	case !changed:
+	case value == StoredPolicy{}:
+		delete(m.storage, key)
+	default:
+		m.storage[key] = value
+	}
+	return nil
+}
+
+func (m *memoryPolicyStorage) Range(f func(key string, val StoredPolicy) bool) {
+	m.Lock()
+	snapshot := make(map[string]StoredPolicy, len(m.storage))
+	for key, val := range m.storage {
+		snapshot[key] = val
+	}
+	m.Unlock()
+	for key, val := range snapshot { // f may use the storage
+		if !f(key, val) {
+			return
+		}
+	}
+}
+
+func (m *memoryPolicyStorage) UpdateRules(update func(rs []rules.Rule) []rules.Rule) error {
+	m.Lock()
+	defer m.Unlock()
+	m.rules = append([]rules.Rule(nil), update(append([]rules.Rule(nil), m.rules...))...)
+	return nil
+}
+
+func (m *memoryPolicyStorage) Rules() []rules.Rule {
+	m.Lock()
+	defer m.Unlock()
+	return append([]rules.Rule(nil), m.rules...)
+}
+
+func (m *memoryPolicyStorage) Prune(expired func(key string, val StoredPolicy) bool) int {
+	m.Lock()
+	defer m.Unlock()
+	pruned := 0
+	for key, val := range m.storage {
+		if expired(key, val) {
+			delete(m.storage, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
 func (m *memorySessionStorage) Store(key string, value StoredSession) {
 	m.Lock()
 	defer m.Unlock()
@@ -75,6 +144,36 @@ func (m *memorySessionStorage) Get(key string) (StoredSession, bool) {
 	return value, ok
 }
 
+func (m *memorySessionStorage) Range(f func(key string, value StoredSession) bool) {
+	m.Lock()
+	snapshot := make(map[string]StoredSession, len(m.storage))
+	for key, val := range m.storage {
+		snapshot[key] = val
+	}
+	m.Unlock()
+	for key, val := range snapshot { // f may use the storage
+		if !f(key, val) {
+			return
+		}
+	}
+}
+
+func (m *memoryNonceStorage) Use(nonce string, expires time.Time) bool {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for n, exp := range m.storage { // Forget expired nonces so the map does not grow forever
+		if now.After(exp) {
+			delete(m.storage, n)
+		}
+	}
+	if _, used := m.storage[nonce]; used {
+		return false
+	}
+	m.storage[nonce] = expires
+	return true
+}
+
 // Returns a simple, in-memory Policies implementation. Data will not persist on restart.
 func NewMemoryPolicyStorage() Policies {
 	return &memoryPolicyStorage{storage: map[string]StoredPolicy{}}
@@ -84,3 +183,8 @@ func NewMemoryPolicyStorage() Policies {
 func NewMemorySessionStorage() Sessions {
 	return &memorySessionStorage{storage: map[string]StoredSession{}}
 }
+
+// Returns a simple, in-memory Nonces implementation. Data will not persist on restart.
+func NewMemoryNonceStorage() Nonces {
+	return &memoryNonceStorage{storage: map[string]time.Time{}}
+}
@@ -78,3 +78,17 @@ func TestSessionDeletion(t *testing.T) {
 		t.Error("Session should not be fetchable after it was removed.")
 	}
 }
+
+// Make sure that a nonce can only be used once until it expires
+func TestNonceReuse(t *testing.T) {
+	s := NewMemoryNonceStorage()
+	if !s.Use("nonce", time.Now().Add(time.Minute)) {
+		t.Error("Unused nonce should be accepted.")
+	}
+	if s.Use("nonce", time.Now().Add(time.Minute)) {
+		t.Error("Used nonce should be rejected.")
+	}
+	if !s.Use("other", time.Now().Add(-time.Second)) || !s.Use("other", time.Now().Add(time.Minute)) {
+		t.Error("Expired nonce should be accepted again.")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"github.com/tcorp-bv/backstage-hook/actions"
+)
+
+// Moves the policies that are stored under a hash of an older format (see actions.HashVersion) to the current hash of
+// their action, so earlier decisions survive a change of the hash format. Returns the number of migrated policies.
+func migrateHashes(p Policies) int {
+	migrated := 0
+	p.Range(func(key string, val StoredPolicy) bool {
+		a, ok := recoverAction(key, val)
+		if !ok || a.Hash() == key {
+			return true // Unknown policies are kept, they can still be revoked
+		}
+		if _, exists := p.Get(a.Hash()); !exists { // A decision under the current hash is newer
+			val.Action = &a
+			p.Store(a.Hash(), val)
+		}
+		p.Store(key, StoredPolicy{})
+		migrated++
+		return true
+	})
+	return migrated
+}
+
+// Returns the action of a stored policy, from the policy itself or from a hash in the legacy format.
+func recoverAction(key string, val StoredPolicy) (actions.Action, bool) {
+	if val.Action != nil {
+		return *val.Action, true
+	}
+	return actions.ParseLegacyHash(key)
+}
@@ -0,0 +1,74 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"testing"
+	"time"
+)
+
+// Returns the hash of the action in the format that was used before hashes were versioned.
+func legacyHash(a actions.Action) string {
+	data, _ := json.Marshal(a)
+	return base64.StdEncoding.EncodeToString(sha256.New().Sum(data))
+}
+
+// Makes sure that decisions stored under legacy hashes still apply after the migration.
+func TestMigrateHashes(t *testing.T) {
+	backend := NewMemoryPolicyStorage()
+	old := actions.Action{Plugin: "test", Command: actions.Command{Name: "git", Args: []string{"status"}}}
+	recorded := actions.Action{Plugin: "test", Command: actions.Command{Name: "ls"}}
+	backend.Store(legacyHash(old), StoredPolicy{PolicyId: policies.AllowAlways().Id(), Timestamp: time.Now()})
+	backend.Store("some unknown key", StoredPolicy{PolicyId: policies.AllowAlways().Id(), Action: &recorded})
+	backend.Store("garbage", StoredPolicy{PolicyId: policies.AllowAlways().Id()})
+
+	// The server always resolves the executable, the migrated approvals are pinned to the first one they are used with
+	s := New(backend, nil)
+	git, ls := old, recorded
+	git.Executable = &actions.Executable{Path: "/usr/bin/git", Digest: "1234"}
+	ls.Executable = &actions.Executable{Path: "/bin/ls", Digest: "5678"}
+	assertPolicyValue(t, s, git, policies.AllowAlways())
+	assertPolicyValue(t, s, ls, policies.AllowAlways())
+	assertPolicyValue(t, s, git, policies.AllowAlways())
+	git.Executable = &actions.Executable{Path: "/tmp/git", Digest: "1234"}
+	assertPolicyNotExist(t, s, git)
+	if _, ok := backend.Get(legacyHash(old)); ok {
+		t.Error("the policy under the legacy hash was not removed")
+	}
+	if val, _ := backend.Get(old.Hash()); val.Action == nil || val.Action.Hash() != old.Hash() {
+		t.Error("the recovered action was not recorded")
+	}
+	if _, ok := backend.Get("garbage"); !ok {
+		t.Error("a policy that can not be migrated should be kept")
+	}
+	if migrateHashes(backend) != 0 {
+		t.Error("migrated policies should not be migrated again")
+	}
+}
@@ -24,21 +24,48 @@
 
 package storage
 
-import "time"
+import (
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"time"
+)
 
-// Key value store to remember the last policy (used for allow always).
+// Key value store to remember the last policy (used for allow always) and a list of pattern based rules.
 type Policies interface {
 	// Store some value in key. If val == StoredPolicy{}, this will delete the policy.
 	Store(key string, val StoredPolicy)
 
 	// Get the value of the key, second argument is false if nonexistent
 	Get(key string) (StoredPolicy, bool)
+
+	// Replace the value of key with the value update returns, it is given the stored value and whether it exists. Nothing
+	// changes if update returns false. No other change of the key happens in between, not even by another process that
+	// uses the same storage.
+	Update(key string, update func(val StoredPolicy, ok bool) (StoredPolicy, bool)) error
+
+	// Call f for every stored policy in no particular order, stops when f returns false
+	Range(f func(key string, val StoredPolicy) bool)
+
+	// Replace all stored rules with the rules update returns, it is given the stored rules. No other update of the
+	// rules happens in between, not even by another process that uses the same storage.
+	UpdateRules(update func(rs []rules.Rule) []rules.Rule) error
+
+	// Get all stored rules in the order they were stored
+	Rules() []rules.Rule
+
+	// Delete every stored policy for which expired returns true, returns the number of deleted policies
+	Prune(expired func(key string, val StoredPolicy) bool) int
 }
 
 // The storage representation of a policy.
 type StoredPolicy struct {
 	// The Id value of the policy
 	PolicyId string
-	// The timestamp of when this policy was set (the time the user selected "AllowAlways")
+	// The timestamp of when this policy was set (the time the user selected "AllowAlways"), policies with a
+	// duration (eg. ALLOW_15M) expire relative to it
 	Timestamp time.Time
+	// The hook instance that set this policy, only set for policies that are forgotten when the hook restarts
+	Boot string `json:",omitempty"`
+	// The action the policy was set for, nil for policies that were stored before actions were recorded
+	Action *actions.Action `json:",omitempty"`
 }
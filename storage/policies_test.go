@@ -27,12 +27,21 @@ package storage
 import (
 	"github.com/tcorp-bv/backstage-hook/actions"
 	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMemoryStorage(t *testing.T) {
 	// Runs a bunch of integration tests against the memory storage
 	testPolicyStorage(t, New(NewMemoryPolicyStorage(), nil))
+	testRuleStorage(t, New(NewMemoryPolicyStorage(), nil))
+	testPinnedStorage(t, New(NewMemoryPolicyStorage(), nil))
+	testExpiringStorage(t, NewMemoryPolicyStorage())
+	store := New(NewMemoryPolicyStorage(), nil)
+	testConcurrentRules(t, []PoliciesStore{store, store, store, store})
 }
 
 func testPolicyStorage(t *testing.T, s PoliciesStore) {
@@ -64,6 +73,21 @@ func testPolicyStorage(t *testing.T, s PoliciesStore) {
 		s.SetPolicy(act, policies.AllowAlways())
 		assertPolicyValue(t, s, act, policies.AllowAlways())
 	}
+	// Make sure that all values are listed with their action
+	entries := s.Policies()
+	if len(entries) != len(acts) {
+		t.Error("expected ", len(acts), " policies to be listed, got ", len(entries))
+	}
+	for _, e := range entries {
+		if e.Action == nil || e.Action.Hash() != e.Key || e.Policy != policies.AllowAlways() {
+			t.Error("listed policy does not match the stored policy: ", e)
+		}
+	}
+	// Make sure that a policy can be revoked by its key
+	if !s.RevokePolicy(acts[0].Hash()) || s.RevokePolicy(acts[0].Hash()) {
+		t.Error("RevokePolicy did not return expected values")
+	}
+	assertPolicyNotExist(t, s, acts[0])
 	// Make sure that deleting all values works
 	for _, act := range acts {
 		s.SetPolicy(act, nil)
@@ -73,6 +97,153 @@ func testPolicyStorage(t *testing.T, s PoliciesStore) {
 	}
 }
 
+// Makes sure that rules added at the same time through the given stores are all kept.
+func testConcurrentRules(t *testing.T, stores []PoliciesStore) {
+	var wg sync.WaitGroup
+	for i, s := range stores {
+		wg.Add(1)
+		go func(s PoliciesStore, writer int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				r := rules.Rule{Id: strconv.Itoa(writer) + "-" + strconv.Itoa(j), Command: "git", Decision: rules.Allow}
+				if err := s.AddRule(r); err != nil {
+					t.Error(err)
+				}
+			}
+		}(s, i)
+	}
+	wg.Wait()
+	if rs := stores[0].Rules(); len(rs) != len(stores)*10 {
+		t.Errorf("expected %d rules, got %d", len(stores)*10, len(rs))
+	}
+}
+
+func testRuleStorage(t *testing.T, s PoliciesStore) {
+	git := actions.Action{Plugin: "test", Command: actions.Command{Name: "git", Args: []string{"status", "-s"}}}
+	allowGit := rules.Rule{Id: "git", Command: "git", Args: []rules.Pattern{rules.AnyArgs}, Decision: rules.Allow}
+	denyStatus := rules.Rule{Id: "status", Priority: 1, Command: "git", Args: []rules.Pattern{"status", rules.AnyArgs}, Decision: rules.Deny}
+
+	if s.AddRule(rules.Rule{Id: "invalid"}) == nil || len(s.Rules()) != 0 {
+		t.Error("invalid rule should not be stored")
+	}
+	if err := s.AddRule(allowGit); err != nil {
+		t.Fatal(err)
+	}
+	assertPolicyValue(t, s, git, policies.AllowSimilar())
+
+	// A rule with a higher priority takes precedence
+	_ = s.AddRule(denyStatus)
+	assertPolicyValue(t, s, git, policies.Deny())
+	if m, _ := s.Match(git); m.Rule == nil || m.Rule.Id != denyStatus.Id {
+		t.Error("Match did not return the deciding rule")
+	}
+
+	// A stored policy for the exact action takes precedence over rules
+	s.SetPolicy(git, policies.AllowAlways())
+	assertPolicyValue(t, s, git, policies.AllowAlways())
+	if m, _ := s.Match(git); m.Rule != nil {
+		t.Error("Match returned a rule for a policy stored for the exact action")
+	}
+	s.SetPolicy(git, nil)
+
+	// Adding a rule with an existing id replaces it
+	denyStatus.Decision = rules.Allow
+	_ = s.AddRule(denyStatus)
+	if len(s.Rules()) != 2 {
+		t.Error("rule with an existing id was not replaced")
+	}
+	assertPolicyValue(t, s, git, policies.AllowSimilar())
+
+	if !s.RemoveRule("status") || !s.RemoveRule("git") || s.RemoveRule("git") {
+		t.Error("RemoveRule did not return expected values")
+	}
+	assertPolicyNotExist(t, s, git)
+
+	// Only the rules with the prefix are replaced
+	_ = s.AddRule(allowGit)
+	fileRule := denyStatus
+	fileRule.Id = "file:status"
+	if s.ReplaceRules("file:", []rules.Rule{fileRule, {Id: "file:invalid"}}) == nil || len(s.Rules()) != 1 {
+		t.Error("invalid rules should not be stored")
+	}
+	if err := s.ReplaceRules("file:", []rules.Rule{fileRule}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReplaceRules("file:", []rules.Rule{fileRule}); err != nil || len(s.Rules()) != 2 {
+		t.Error("rules with the prefix were not replaced: ", s.Rules())
+	}
+	if err := s.ReplaceRules("file:", nil); err != nil || len(s.Rules()) != 1 || s.Rules()[0].Id != "git" {
+		t.Error("rules with the prefix were not removed: ", s.Rules())
+	}
+	s.RemoveRule("git")
+}
+
+func testExpiringStorage(t *testing.T, backend Policies) {
+	acts := generateUniqueActions()
+	s := New(backend, nil)
+
+	// Time bound policies apply until their duration passed
+	s.SetPolicy(acts[0], policies.AllowFor15Minutes())
+	assertPolicyValue(t, s, acts[0], policies.AllowFor15Minutes())
+	backend.Store(acts[1].Hash(), StoredPolicy{PolicyId: policies.AllowFor15Minutes().Id(), Timestamp: time.Now().Add(-time.Hour)})
+	assertPolicyNotExist(t, s, acts[1])
+
+	// Policies that last until a restart are forgotten by the next hook instance
+	s.SetPolicy(acts[2], policies.AllowUntilRestart())
+	s.SetPolicy(acts[3], policies.AllowAlways())
+	assertPolicyValue(t, s, acts[2], policies.AllowUntilRestart())
+	restarted := New(backend, nil)
+	assertPolicyNotExist(t, restarted, acts[2])
+	assertPolicyValue(t, restarted, acts[3], policies.AllowAlways())
+
+	// Only policies whose duration passed are pruned from the backend, the previous instance may still be running
+	if pruned := restarted.PruneExpired(); pruned != 1 {
+		t.Error("expected 1 expired policy to be pruned, got ", pruned)
+	}
+	if _, ok := backend.Get(acts[1].Hash()); ok {
+		t.Error("expired policy was not pruned")
+	}
+	assertPolicyValue(t, s, acts[2], policies.AllowUntilRestart())
+	assertPolicyValue(t, restarted, acts[0], policies.AllowFor15Minutes())
+	assertPolicyValue(t, restarted, acts[3], policies.AllowAlways())
+	for _, act := range acts[:4] {
+		restarted.SetPolicy(act, nil)
+	}
+}
+
+func testPinnedStorage(t *testing.T, s PoliciesStore) {
+	acts := generateUniqueActions()
+	allowed, denied := acts[0], acts[1]
+	allowed.Executable = &actions.Executable{Path: "/usr/bin/git", Digest: "1234"}
+	denied.Executable = allowed.Executable
+	s.SetPolicy(allowed, policies.AllowAlways())
+	s.SetPolicy(denied, policies.DenyAlways())
+	assertPolicyValue(t, s, allowed, policies.AllowAlways())
+
+	// An approval only applies to the executable it was given for
+	swapped := &actions.Executable{Path: "/usr/bin/git", Digest: "5678"}
+	for _, exe := range []*actions.Executable{swapped, {Path: "/tmp/git", Digest: "1234"}, nil} {
+		allowed.Executable, denied.Executable = exe, exe
+		assertPolicyNotExist(t, s, allowed)
+		assertPolicyValue(t, s, denied, policies.DenyAlways())
+	}
+
+	// Deciding again pins the new executable
+	allowed.Executable = swapped
+	s.SetPolicy(allowed, policies.AllowAlways())
+	assertPolicyValue(t, s, allowed, policies.AllowAlways())
+
+	// An approval that was given without an executable is pinned to the first one it is used with
+	allowed.Executable = nil
+	s.SetPolicy(allowed, policies.AllowAlways())
+	allowed.Executable = swapped
+	assertPolicyValue(t, s, allowed, policies.AllowAlways())
+	allowed.Executable = &actions.Executable{Path: "/tmp/git", Digest: "5678"}
+	assertPolicyNotExist(t, s, allowed)
+	s.SetPolicy(allowed, nil)
+	s.SetPolicy(denied, nil)
+}
+
 func assertPolicyValue(t *testing.T, s PoliciesStore, act actions.Action, pol policies.Policy) {
 	p, contains := s.Policy(act)
 	if p == nil || p != pol || !contains {
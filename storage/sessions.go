@@ -33,6 +33,9 @@ type Sessions interface {
 
 	// Get the session of the key, second argument is false if nonexistent
 	Get(key string) (StoredSession, bool)
+
+	// Call f for every stored session in no particular order, stops when f returns false
+	Range(f func(key string, value StoredSession) bool)
 }
 
 // A session, this allows for the hook to make sure that only approved clients (eg. Backstage) can communicate with it.
@@ -64,6 +64,10 @@ func testSessionStorage(t *testing.T, s SessionsStore) {
 		s.SetSession(ses)
 		assertSessionValue(t, s, ses)
 	}
+	// Make sure that all sessions are listed
+	if ids := s.Sessions(); len(ids) != len(sess) {
+		t.Error("expected ", len(sess), " sessions to be listed, got ", len(ids))
+	}
 	// Make sure that deleting all sessions works
 	for _, ses := range sess {
 		s.DeleteSession(ses.Id())
@@ -25,16 +25,42 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"github.com/tcorp-bv/backstage-hook/actions"
 	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
 	"github.com/tcorp-bv/backstage-hook/sessions"
+	"log"
+	"os"
+	"sort"
 	"time"
 )
 
 // Instantiates a Store object with a Policies and Sessions backend.
 // Example usage: storage.New(storage.NewMemoryPolicyStorage(), storage.NewMemorySessionStorage()))
+// Every Store is a new hook instance: policies that are forgotten on restart (eg. ALLOW_UNTIL_RESTART) and were set
+// through another Store are treated as expired. Policies that are stored under a hash of an older format are migrated.
 func New(pol Policies, ses Sessions) Store {
-	return &store{policyStore: pol, SessionStore: ses}
+	if pol != nil {
+		migrateHashes(pol)
+	}
+	return &store{policyStore: pol, SessionStore: ses, boot: fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())}
+}
+
+// Prunes the policies whose duration passed from the store every interval until ctx is done. Expired policies are never
+// applied, pruning only keeps them from piling up in the backend.
+func Sweep(ctx context.Context, s PoliciesStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.PruneExpired()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 // The external storage interface to store policies and sessions.
@@ -47,11 +73,62 @@ type Store interface {
 
 // External interface to get and set policies to the backend.
 type PoliciesStore interface {
-	// Returns the stored policy and true if the storage contains the policy
+	// Returns the stored policy and true if the storage contains the policy. If no policy was stored for this exact
+	// action, or the stored policy allows another executable, the policy of the matching rule with the highest priority
+	// is returned. An approval that was stored without an executable is pinned to the first one it is used with.
 	Policy(a actions.Action) (p policies.Policy, contains bool)
 
+	// Like Policy, but also returns the rule that decided when no policy was stored for this exact action
+	Match(a actions.Action) (m Match, contains bool)
+
 	// Stores the given action to the storage. If p == nil, this will delete the policy.
 	SetPolicy(a actions.Action, p policies.Policy)
+
+	// Returns all stored policies whose duration did not pass, in the order they were set. Policies that are forgotten
+	// on restart are included whichever hook instance set them, as a hook that is still running may apply them.
+	Policies() []PolicyEntry
+
+	// Deletes the policy that is stored under key (the hash of its action), returns false if no such policy exists
+	RevokePolicy(key string) bool
+
+	// Returns all stored rules
+	Rules() []rules.Rule
+
+	// Stores the rule, replacing the rule with the same Id if it exists. Returns an error if the rule is invalid.
+	AddRule(r rules.Rule) error
+
+	// Removes the rule with the provided id, returns false if no such rule exists
+	RemoveRule(id string) bool
+
+	// Replaces all rules with an id that starts with prefix by rs (eg. the rules of a policy file). Nothing is replaced
+	// if one of the rules is invalid.
+	ReplaceRules(prefix string, rs []rules.Rule) error
+
+	// Deletes the policies whose duration passed from the backend, returns the number of deleted policies. Policies that
+	// are forgotten on restart are kept, as the hook instance that set them may still be running.
+	PruneExpired() int
+}
+
+// The policy that applies to an action and where it came from.
+type Match struct {
+	// The policy that applies to the action
+	Policy policies.Policy
+	// The rule that decided, nil if the policy was stored for this exact action
+	Rule *rules.Rule
+}
+
+// A policy that is stored for an exact action.
+type PolicyEntry struct {
+	// The key of the policy in the backend, this is the hash of the action
+	Key string
+	// The action, nil if it was stored before actions were recorded
+	Action *actions.Action
+	// The stored policy
+	Policy policies.Policy
+	// The time the policy was set
+	Set time.Time
+	// The time the policy expires, zero if it does not expire or expires when the hook restarts
+	Expires time.Time
 }
 
 // External interface to get and set sessions to the backend.
@@ -64,20 +141,84 @@ type SessionsStore interface {
 
 	//Removes the session with the provided id if it exists
 	DeleteSession(id string)
+
+	// Returns the ids of all stored sessions
+	Sessions() []string
 }
 
 type store struct {
 	policyStore  Policies
 	SessionStore Sessions
+	// Identifies this hook instance, see New
+	boot string
 }
 
 func (s *store) Policy(a actions.Action) (policies.Policy, bool) {
+	m, ok := s.Match(a)
+	return m.Policy, ok
+}
+
+func (s *store) Match(a actions.Action) (Match, bool) {
 	val, ok := s.policyStore.Get(a.Hash())
-	if !ok || (val == StoredPolicy{}) || !policies.IdValid(val.PolicyId) {
-		return nil, false
+	if !ok || (val == StoredPolicy{}) || !policies.IdValid(val.PolicyId) || s.expired(val) {
+		return s.matchRule(a)
 	}
 	p, _ := policies.ById(val.PolicyId)
-	return p, true
+	if p.Allows() && !s.pinned(val, a) {
+		return s.matchRule(a)
+	}
+	return Match{Policy: p}, true
+}
+
+// Whether the stored policy was set for the executable of a, an approval does not carry over to another executable
+// (eg. when the binary was replaced or another one comes first in PATH). Policies that deny apply to any executable.
+// An approval that was stored without an executable (eg. before executables were recorded, or migrated from an older
+// hash format) is pinned to the executable it is first used with. It is only pinned if it was not changed (eg. revoked)
+// since val was read, otherwise it does not apply.
+func (s *store) pinned(val StoredPolicy, a actions.Action) bool {
+	if val.Action != nil && val.Action.Executable != nil {
+		return val.Action.Executable.Equal(a.Executable)
+	}
+	pinned := false
+	err := s.policyStore.Update(a.Hash(), func(cur StoredPolicy, ok bool) (StoredPolicy, bool) {
+		if !ok || cur.PolicyId != val.PolicyId || !cur.Timestamp.Equal(val.Timestamp) || cur.Boot != val.Boot {
+			return cur, false
+		}
+		if cur.Action != nil && cur.Action.Executable != nil { // Pinned in the meantime
+			pinned = cur.Action.Executable.Equal(a.Executable)
+			return cur, false
+		}
+		cur.Action, pinned = &a, true
+		return cur, true
+	})
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return pinned
+}
+
+// Whether the stored policy no longer applies because its duration passed or the hook restarted since it was set.
+func (s *store) expired(val StoredPolicy) bool {
+	p, err := policies.ById(val.PolicyId)
+	if err != nil {
+		return false // Unknown policies are never applied, but they are kept in case a newer version knows them
+	}
+	return durationPassed(p, val) || (p.UntilRestart() && val.Boot != s.boot)
+}
+
+// Whether the duration of the stored policy p passed.
+func durationPassed(p policies.Policy, val StoredPolicy) bool {
+	return p.Duration() > 0 && time.Now().After(val.Timestamp.Add(p.Duration()))
+}
+
+// Returns the policy of the matching rule with the highest priority.
+func (s *store) matchRule(a actions.Action) (Match, bool) {
+	r, ok := rules.Match(s.policyStore.Rules(), a)
+	if !ok || r.Decision.Policy() == nil {
+		return Match{}, false
+	}
+	return Match{Policy: r.Decision.Policy(), Rule: &r}, true
 }
 
 func (s *store) SetPolicy(a actions.Action, pol policies.Policy) {
@@ -85,7 +226,103 @@ func (s *store) SetPolicy(a actions.Action, pol policies.Policy) {
 		s.policyStore.Store(a.Hash(), StoredPolicy{})
 		return
 	}
-	s.policyStore.Store(a.Hash(), StoredPolicy{PolicyId: pol.Id(), Timestamp: time.Now()})
+	val := StoredPolicy{PolicyId: pol.Id(), Timestamp: time.Now(), Action: &a}
+	if pol.UntilRestart() {
+		val.Boot = s.boot
+	}
+	s.policyStore.Store(a.Hash(), val)
+}
+
+func (s *store) Policies() []PolicyEntry {
+	var entries []PolicyEntry
+	s.policyStore.Range(func(key string, val StoredPolicy) bool {
+		p, err := policies.ById(val.PolicyId)
+		if err != nil || durationPassed(p, val) { // The running hook sweeps the policies of previous instances
+			return true
+		}
+		e := PolicyEntry{Key: key, Action: val.Action, Policy: p, Set: val.Timestamp}
+		if p.Duration() > 0 {
+			e.Expires = val.Timestamp.Add(p.Duration())
+		}
+		entries = append(entries, e)
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Set.Equal(entries[j].Set) {
+			return entries[i].Set.Before(entries[j].Set)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+func (s *store) RevokePolicy(key string) bool {
+	if _, ok := s.policyStore.Get(key); !ok {
+		return false
+	}
+	s.policyStore.Store(key, StoredPolicy{})
+	return true
+}
+
+func (s *store) ReplaceRules(prefix string, rs []rules.Rule) error {
+	for _, r := range rs {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %s: %v", r.Id, err)
+		}
+	}
+	return s.policyStore.UpdateRules(func(stored []rules.Rule) []rules.Rule {
+		var kept []rules.Rule
+		for _, r := range stored {
+			if len(r.Id) < len(prefix) || r.Id[:len(prefix)] != prefix {
+				kept = append(kept, r)
+			}
+		}
+		return append(kept, rs...)
+	})
+}
+
+func (s *store) PruneExpired() int {
+	return s.policyStore.Prune(func(key string, val StoredPolicy) bool {
+		p, err := policies.ById(val.PolicyId)
+		return err == nil && durationPassed(p, val)
+	})
+}
+
+func (s *store) Rules() []rules.Rule {
+	return s.policyStore.Rules()
+}
+
+func (s *store) AddRule(r rules.Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	return s.policyStore.UpdateRules(func(rs []rules.Rule) []rules.Rule {
+		for i := range rs {
+			if rs[i].Id == r.Id {
+				rs[i] = r
+				return rs
+			}
+		}
+		return append(rs, r)
+	})
+}
+
+func (s *store) RemoveRule(id string) bool {
+	removed := false
+	err := s.policyStore.UpdateRules(func(rs []rules.Rule) []rules.Rule {
+		for i := range rs {
+			if rs[i].Id == id {
+				removed = true
+				return append(rs[:i], rs[i+1:]...)
+			}
+		}
+		return rs
+	})
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return removed
 }
 
 func (s *store) Session(id string) (session sessions.Session, contains bool) {
@@ -106,3 +343,13 @@ func (s *store) SetSession(session sessions.Session) {
 func (s *store) DeleteSession(id string) {
 	s.SessionStore.Store(id, StoredSession{})
 }
+
+func (s *store) Sessions() []string {
+	var ids []string
+	s.SessionStore.Range(func(key string, value StoredSession) bool {
+		ids = append(ids, key)
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
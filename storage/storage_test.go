@@ -24,7 +24,13 @@
 
 package storage
 
-import "testing"
+import (
+	"context"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"testing"
+	"time"
+)
 
 func TestSetNilSessionPanics(t *testing.T) {
 	defer func() {
@@ -35,3 +41,56 @@ func TestSetNilSessionPanics(t *testing.T) {
 	s := store{SessionStore: NewMemorySessionStorage()}
 	s.SetSession(nil)
 }
+
+// Makes sure that the sweeper prunes expired policies until it is stopped.
+func TestSweep(t *testing.T) {
+	backend := NewMemoryPolicyStorage()
+	s := New(backend, nil)
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "ls"}}
+	backend.Store(a.Hash(), StoredPolicy{PolicyId: policies.AllowFor15Minutes().Id(), Timestamp: time.Now().Add(-time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Sweep(ctx, s, time.Millisecond)
+		close(done)
+	}()
+	for i := 0; i < 500; i++ {
+		if _, ok := backend.Get(a.Hash()); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := backend.Get(a.Hash()); ok {
+		t.Error("expired policy was not pruned")
+	}
+	cancel()
+	<-done
+}
+
+// Backend that revokes every policy right after it was read, like another process that revokes it concurrently.
+type revokingBackend struct {
+	Policies
+}
+
+func (r revokingBackend) Get(key string) (StoredPolicy, bool) {
+	val, ok := r.Policies.Get(key)
+	r.Policies.Store(key, StoredPolicy{})
+	return val, ok
+}
+
+// Makes sure that pinning an approval to its executable does not restore it when it was revoked in the meantime.
+func TestPinRevoked(t *testing.T) {
+	backend := NewMemoryPolicyStorage()
+	a := actions.Action{Plugin: "test", Command: actions.Command{Name: "ls"}}
+	backend.Store(a.Hash(), StoredPolicy{PolicyId: policies.AllowAlways().Id(), Timestamp: time.Now()})
+
+	s := New(revokingBackend{backend}, nil)
+	a.Executable = &actions.Executable{Path: "/bin/ls", Digest: "1234"}
+	if _, ok := s.Policy(a); ok {
+		t.Error("the revoked approval applies")
+	}
+	if _, ok := backend.Get(a.Hash()); ok {
+		t.Error("the revoked approval was restored")
+	}
+}
@@ -25,68 +25,119 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"github.com/tcorp-bv/backstage-hook/actions"
 	"github.com/tcorp-bv/backstage-hook/cli"
 	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 )
 
 // TODO: See https://github.com/tcorp-bv/backstage-hook/issues/1: Make the CLI compatible with small terminals (in #characters)
 
 const (
-	promptHeight = 6
+	promptHeight = 9
 )
 
-// Contains all relevant properties of an action request.
+// Contains all relevant properties of an action request or a pairing request.
 type requestResponse struct {
+	// Identifies the request in the queue while other requests are added and removed
+	Id int
 	// The actual request
 	Req actions.Action
 	// The response (eg. AllowAlways)
 	Res chan policies.Policy
+	// The pairing code, only set for pairing requests
+	Pairing string
+	// The response to a pairing request, true if the user confirmed the code
+	Confirm chan bool
 	// The command is stored in a temporary file for the user to view. This is an extra measurement against injecting a command that hides itself through console properties.
 	File *os.File
 }
 
 // Generates the file in the temporary directory if nonexistent and returns its url
-func (r *requestResponse) FileURI() string {
+func (r *requestResponse) FileURI() (string, error) {
 	if r.File == nil {
-		r.generateFile()
+		if err := r.generateFile(); err != nil {
+			return "", err
+		}
 	}
 	path, err := filepath.Abs(r.File.Name())
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return fmt.Sprintf("file://%s", path)
+	return fmt.Sprintf("file://%s", path), nil
 }
 
-// Generates a new file and sets the command as its contents.
-func (r *requestResponse) generateFile() {
+// Generates a new file and sets the command, the executable that runs it, its directory, environment, the passed
+// variables and input as its contents. If the file can not be written, it is removed and an error is returned.
+func (r *requestResponse) generateFile() error {
 	f, err := ioutil.TempFile(os.TempDir(), "*-command.txt")
-	defer func() {	
-		err = f.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-	r.File = f
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	_, err = f.WriteString(r.Req.Command.String())
+	_, err = f.WriteString(r.Req.Command.String() + "\n")
+	if exe := r.Req.Executable; err == nil && exe != nil {
+		_, err = fmt.Fprintf(f, "\nExecutable: %s\nSHA-256: %s\n", exe.Path, exe.Digest)
+	}
+	if err == nil && r.Req.Command.Dir != "" {
+		_, err = fmt.Fprintf(f, "\nDirectory: %s\n", r.Req.Command.Dir)
+	}
+	if env := r.Req.Command.Environ(); err == nil && len(env) > 0 {
+		_, err = fmt.Fprintf(f, "\nEnvironment:\n%s\n", strings.Join(env, "\n"))
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(f, "\n%s\n", passEnvString(r.Req.PassEnv))
+	}
+	if err == nil && r.Req.Command.Stdin != "" {
+		_, err = fmt.Fprintf(f, "\nInput:\n%s", r.Req.Command.Stdin)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		log.Fatal(err)
+		_ = os.Remove(f.Name())
+		return err
+	}
+	r.File = f
+	return nil
+}
+
+// Sends the decision to the response channel and removes the temporary file.
+func (r *requestResponse) respond(policy policies.Policy) {
+	if r.Pairing != "" {
+		r.Confirm <- policy.Allows()
+		return
+	}
+	r.Res <- policy
+	r.removeFile()
+}
+
+// Removes the temporary file of the command, if it was generated. A file that can not be removed is left in the
+// temporary directory.
+func (r *requestResponse) removeFile() {
+	if r.File == nil {
+		return
+	}
+	if err := os.Remove(r.File.Name()); err != nil {
+		log.Print(err)
 	}
 }
 
-// Creates the command-line interface UI frontend for the hook.
+// Creates the command-line interface UI frontend for the hook. If the reader of the app is a terminal, it is put in raw
+// mode: the arrow keys select a request in the queue, a single key decides on it and enter shows its details.
+// Otherwise a decision is typed and confirmed with enter, and the requests are decided in order.
 func NewCli(app *cli.App) UI {
-	return &cliUI{App: app, queue: []requestResponse{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cliUI{App: app, queue: []requestResponse{}, ctx: ctx, cancel: cancel, interrupt: interrupt}
 }
 
 // Interactive CLI frontend for backstage-hook
@@ -94,6 +145,27 @@ type cliUI struct {
 	sync.Mutex
 	App   *cli.App
 	queue []requestResponse // Queued actions
+	// The index in the queue of the request that is shown in the prompt, always 0 if the terminal is not in raw mode
+	selected int
+	// The id of the request the user selected, keys only act on it. It is gone when that request was withdrawn.
+	selectedId int
+	// Whether all details of the selected request are shown instead of the queue
+	expanded bool
+	// Restores the terminal, nil if it is not in raw mode
+	restore func() error
+	// Done when the UI is closed, this stops reading input
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Whether the UI is closed, requests are denied without asking
+	closed bool
+	// Called when Ctrl-C is pressed in raw mode, after the UI is closed
+	interrupt func()
+	// Whether handlePrompt is reading the input, only used if the terminal is not in raw mode
+	prompting bool
+	// The id of the request that was shown when handlePrompt started reading the input, the input decides on it
+	prompted int
+	// The id of the last queued request
+	lastId int
 }
 
 // Writes the header to the top of the output
@@ -112,8 +184,15 @@ func (c *cliUI) writeQueue() {
 
 	//Move cursor just above the prompt
 	fmt.Fprintf(c.App.Writer, "%s%s", cli.CursorBottom, cli.CursorUp(promptHeight))
-	for i := 1; i < len(c.queue); i++ {
+	for i := range c.queue {
+		if i == c.selected {
+			continue
+		}
 		req := c.queue[i].Req
+		if c.queue[i].Pairing != "" {
+			fmt.Fprintf(c.App.Writer, "%s%s%d. pairing request from Backstage", cli.CursorUp(1).String(), cli.CursorLeft, i+1)
+			continue
+		}
 		// Move a line up and print a truncated version of the command and plugin
 		fmt.Fprintf(c.App.Writer, "%s%s%d. %.20q by %q", cli.CursorUp(1).String(), cli.CursorLeft, i+1, req.Command.Name+"...", req.Plugin)
 	}
@@ -126,10 +205,117 @@ func (c *cliUI) writePrompt() {
 	}
 	fmt.Fprintf(c.App.Writer,
 		"%s%s%s", cli.CursorBottom, cli.CursorLeft, cli.CursorUp(promptHeight-1))
-	fmt.Fprintf(c.App.Writer, "%s By %q:\n\n", cli.GreenColor.Format("1. NEW REQUEST"+string(len(c.queue))), c.queue[0].Req.Plugin)
-	fmt.Fprintf(c.App.Writer, "     %s\n", cli.WhiteColor.Format(fmt.Sprintf("%.100q", c.queue[0].Req.Command.String())))
-	fmt.Fprintf(c.App.Writer, "Full command at %s\n\n", c.queue[0].FileURI()) // Todo: check behavior of this when previous line overflows
-	fmt.Fprintf(c.App.Writer, "%s/%s/%s: ", decisionString(policies.Deny()), decisionString(policies.Allow()), decisionString(policies.AllowAlways()))
+	r := &c.queue[c.selected]
+	if r.Pairing != "" {
+		c.writePairingPrompt()
+		return
+	}
+	fmt.Fprintf(c.App.Writer, "%s By %q:\n\n", cli.GreenColor.Format(fmt.Sprintf("%d. NEW REQUEST", c.selected+1)), r.Req.Plugin)
+	fmt.Fprintf(c.App.Writer, "     %s\n", cli.WhiteColor.Format(fmt.Sprintf("%.100q", r.Req.Command.String())))
+	fmt.Fprintf(c.App.Writer, "Runs %s\n", executableString(r.Req.Executable))
+	fmt.Fprintf(c.App.Writer, "%.100s\n", contextString(r.Req.Command))
+	fmt.Fprintf(c.App.Writer, "%.100s\n", passEnvString(r.Req.PassEnv))
+	if uri, err := r.FileURI(); err == nil {
+		fmt.Fprintf(c.App.Writer, "Full command at %s\n", uri) // Todo: check behavior of this when previous line overflows
+	} else {
+		fmt.Fprintf(c.App.Writer, "%.100s\n", fmt.Sprintf("Full command could not be stored: %v", err))
+	}
+	fmt.Fprintf(c.App.Writer, "%s will %.100s\n", policies.AllowSimilar().Name(), rules.FromAction(r.Req, rules.Allow))
+	fmt.Fprintf(c.App.Writer, "%s/%s/%s/%s/%s/%s/%s%s", decisionString(policies.Deny()), decisionString(policies.DenyAlways()), decisionString(policies.Allow()),
+		decisionString(policies.AllowFor15Minutes()), decisionString(policies.AllowUntilRestart()), decisionString(policies.AllowAlways()), decisionString(policies.AllowSimilar()), c.keysHint())
+}
+
+// Ends the last line of the prompt: how to select and expand requests in raw mode, otherwise a colon to type after.
+func (c *cliUI) keysHint() string {
+	if c.restore == nil {
+		return ": "
+	}
+	details := "details"
+	if c.expanded {
+		details = "queue"
+	}
+	return fmt.Sprintf(" %s", cli.WhiteColor.Format(fmt.Sprintf("[up/down] select, [enter] %s", details)))
+}
+
+// Writes all details of the selected request below the header, in place of the queue. Every value is quoted, so it
+// can not hide itself or the rest of the request with escape codes.
+func (c *cliUI) writeDetails() {
+	r := c.queue[c.selected]
+	w := c.App.Writer
+	fmt.Fprintf(w, "%s%s%s", cli.CursorTo(4, 1), cli.ClearLine, cli.YellowColor.Format(fmt.Sprintf("DETAILS of request %d:\n", c.selected+1)))
+	if r.Pairing != "" {
+		fmt.Fprintf(w, "Pairing code:  %q\n", r.Pairing)
+		return
+	}
+	fmt.Fprintf(w, "Plugin:        %q\n", r.Req.Plugin)
+	fmt.Fprintf(w, "Command:       %q\n", r.Req.Command.Name)
+	for i, arg := range r.Req.Command.Args {
+		fmt.Fprintf(w, "Argument %-5s %q\n", fmt.Sprintf("%d:", i+1), arg)
+	}
+	if exe := r.Req.Executable; exe != nil {
+		fmt.Fprintf(w, "Executable:    %q\n", exe.Path)
+		fmt.Fprintf(w, "SHA-256:       %s\n", exe.Digest)
+	}
+	if r.Req.Command.Dir != "" {
+		fmt.Fprintf(w, "Directory:     %q\n", r.Req.Command.Dir)
+	}
+	for _, env := range r.Req.Command.Environ() {
+		fmt.Fprintf(w, "Environment:   %q\n", env)
+	}
+	fmt.Fprintf(w, "%s\n", passEnvString(r.Req.PassEnv))
+	if r.Req.Command.Stdin != "" {
+		fmt.Fprintf(w, "Input:         %q\n", r.Req.Command.Stdin)
+	}
+}
+
+// Formats the executable for the prompt, the digest is shortened as the full digest is in the command file.
+func executableString(exe *actions.Executable) string {
+	if exe == nil {
+		return "an unresolved executable"
+	}
+	if len(exe.Digest) > 12 {
+		return fmt.Sprintf("%.100q (sha256 %s...)", exe.Path, exe.Digest[:12])
+	}
+	return fmt.Sprintf("%.100q", exe.Path)
+}
+
+// Summarizes the directory, environment and input of the command for the prompt, eg.
+// In "/home/user/src", sets GIT_PAGER, 12 bytes of input.
+func contextString(c actions.Command) string {
+	dir := "In the directory of the hook"
+	if c.Dir != "" {
+		dir = fmt.Sprintf("In %q", c.Dir)
+	}
+	env := "sets no environment variables"
+	if len(c.Env) > 0 {
+		names := make([]string, 0, len(c.Env))
+		for name := range c.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		env = "sets " + strings.Join(names, ", ")
+	}
+	input := "no input"
+	if c.Stdin != "" {
+		input = fmt.Sprintf("%d bytes of input", len(c.Stdin))
+	}
+	return fmt.Sprintf("%s, %s, %s", dir, env, input)
+}
+
+// Lists the variables of the environment of the hook that are passed to the command.
+func passEnvString(names []string) string {
+	if len(names) == 0 {
+		return "Passes no variables from your environment"
+	}
+	return fmt.Sprintf("Passes %s from your environment", strings.Join(names, ", "))
+}
+
+// Writes the prompt to confirm a pairing code, it has the same height as the action prompt.
+func (c *cliUI) writePairingPrompt() {
+	fmt.Fprintf(c.App.Writer, "%s Backstage wants to connect:\n\n", cli.GreenColor.Format(fmt.Sprintf("%d. PAIRING REQUEST", c.selected+1)))
+	fmt.Fprintf(c.App.Writer, "     Code %s\n", cli.WhiteColor.Format(fmt.Sprintf("%.20q", c.queue[c.selected].Pairing)))
+	fmt.Fprintf(c.App.Writer, "Only allow if Backstage shows the same code and you started pairing.\n\n\n\n\n")
+	fmt.Fprintf(c.App.Writer, "%s/%s%s", decisionString(policies.Deny()), decisionString(policies.Allow()), c.keysHint())
 }
 
 // Sets up the command line interface. This includes setting the title and clearing the screen.
@@ -141,12 +327,204 @@ func (c *cliUI) Setup() {
 
 	c.writeHeader()
 
+	if f, ok := c.App.Reader.(*os.File); ok {
+		if restore, err := cli.MakeRaw(f); err == nil {
+			c.restore = restore
+			go c.readKeys()
+		}
+	}
+}
+
+// Denies the requests that are still pending and restores the terminal. Requests that arrive later are denied
+// without asking.
+func (c *cliUI) Close() {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.cancel()
+	for _, r := range c.queue {
+		r.respond(policies.Deny())
+	}
+	if len(c.queue) > 0 {
+		fmt.Fprintf(c.App.Writer, "%s%s\n", cli.CursorBottom, cli.RedColor.Format(fmt.Sprintf("\nDenied %d pending requests", len(c.queue))))
+	}
+	c.queue = nil
+	c.selected = 0
+	if c.restore != nil {
+		if err := c.restore(); err != nil {
+			log.Print(err)
+		}
+		c.restore = nil
+	}
+}
+
+// Closes the UI and so restores the terminal if the calling goroutine panics, then panics again. It must be deferred
+// by every goroutine that renders the UI, as the process exits with the terminal in raw mode otherwise.
+func (c *cliUI) closeOnPanic() {
+	if r := recover(); r != nil {
+		c.Close()
+		panic(r)
+	}
+}
+
+// Handles the keys the user presses in raw mode until the UI is closed.
+func (c *cliUI) readKeys() {
+	defer c.closeOnPanic()
+	for {
+		key, err := c.App.GetKey(c.ctx)
+		if err != nil {
+			c.Close() // Nothing can be decided anymore if the input ended
+			return
+		}
+		if key == cli.KeyInterrupt {
+			c.Close()
+			c.interrupt()
+			return
+		}
+		c.handleKey(key)
+	}
+}
+
+// Selects, expands or decides on a request in the queue.
+func (c *cliUI) handleKey(key cli.Key) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed || len(c.queue) == 0 {
+		return
+	}
+	if c.indexOf(c.selectedId) < 0 { // The key was meant for a withdrawn request, not for the one shown in its place
+		c.selectedId = c.queue[c.selected].Id
+		c.render()
+		return
+	}
+	switch key {
+	case cli.KeyUp:
+		if c.selected > 0 {
+			c.selected--
+			c.expanded = false
+		}
+	case cli.KeyDown:
+		if c.selected < len(c.queue)-1 {
+			c.selected++
+			c.expanded = false
+		}
+	case cli.KeyEnter:
+		c.expanded = !c.expanded
+	default:
+		policy, err := policies.ByShortcut(string(key))
+		if err != nil {
+			return // Not a decision
+		}
+		c.queue[c.selected].respond(policy)
+		c.remove(c.selected)
+		if len(c.queue) == 0 {
+			c.render()
+			return
+		}
+	}
+	c.selectedId = c.queue[c.selected].Id
+	c.render()
+}
+
+// Interrupts the hook like Ctrl-C does outside of raw mode, so it shuts down.
+func interrupt() {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		_ = p.Signal(os.Interrupt)
+	}
 }
 
 // Handles an incoming action request by adding it to the queue and updating the display.
-func (c *cliUI) Handle(req actions.Action, res chan policies.Policy) {
-	c.queue = append(c.queue, requestResponse{Req: req, Res: res})
+// Handle may be called concurrently, eg. by the hook server for every incoming request.
+// The request is denied if its command can not be stored in a file for the user to view.
+func (c *cliUI) Handle(ctx context.Context, req actions.Action, res chan policies.Policy) {
+	defer c.closeOnPanic()
+	r := requestResponse{Req: req, Res: res}
+	if err := r.generateFile(); err != nil {
+		log.Printf("Denied a request of %q, its command could not be stored: %v", req.Plugin, err)
+		res <- policies.Deny()
+		return
+	}
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		r.respond(policies.Deny())
+		return
+	}
+	id := c.add(r)
+	c.Unlock()
 	c.handleQueue()
+	go func() {
+		defer c.closeOnPanic()
+		<-ctx.Done()
+		c.withdraw(id)
+	}()
+}
+
+// Handles an incoming pairing request by adding it to the queue and updating the display.
+func (c *cliUI) Pair(ctx context.Context, code string, res chan bool) {
+	defer c.closeOnPanic()
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		res <- false
+		return
+	}
+	id := c.add(requestResponse{Pairing: code, Confirm: res})
+	c.Unlock()
+	c.handleQueue()
+	go func() {
+		defer c.closeOnPanic()
+		<-ctx.Done()
+		c.withdraw(id)
+	}()
+}
+
+// Appends the request to the queue with a new id and returns the id. The request is selected if the queue was empty.
+func (c *cliUI) add(r requestResponse) int {
+	c.lastId++
+	r.Id = c.lastId
+	c.queue = append(c.queue, r)
+	if len(c.queue) == 1 {
+		c.selectedId = r.Id
+	}
+	return r.Id
+}
+
+// Removes the request with the id from the queue, if the user did not decide on it yet.
+func (c *cliUI) withdraw(id int) {
+	c.Lock()
+	defer c.Unlock()
+	i := c.indexOf(id)
+	if i < 0 {
+		return
+	}
+	c.queue[i].removeFile()
+	c.remove(i)
+	c.render()
+}
+
+// Returns the index in the queue of the request with the id, or -1 if it is not queued.
+func (c *cliUI) indexOf(id int) int {
+	for i, r := range c.queue {
+		if r.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Removes the request at index i from the queue and keeps the selection on the same request if it is not removed.
+func (c *cliUI) remove(i int) {
+	c.queue = append(c.queue[:i], c.queue[i+1:]...)
+	if i == c.selected {
+		c.expanded = false
+	}
+	if c.selected > i || (c.selected > 0 && c.selected == len(c.queue)) {
+		c.selected--
+	}
 }
 
 // Updates the display and starts the display prompt if a new item requires approval.
@@ -155,7 +533,7 @@ func (c *cliUI) handleQueue() {
 	defer c.Unlock()
 	c.render()
 
-	if len(c.queue) == 0 {
+	if len(c.queue) == 0 || c.restore != nil { // In raw mode, the keys are handled by readKeys
 		return
 	}
 	if len(c.queue) > 1 {
@@ -180,43 +558,83 @@ func (c *cliUI) updateDisplayedPrompt() {
 	}
 
 	c.writePrompt()
-	go c.handlePrompt()
+	if !c.prompting {
+		c.prompting = true
+		go c.handlePrompt()
+	}
 }
 
-// Handles the actual prompt input
+// Handles the actual prompt input, the input decides on the request that was shown when reading it started. Stops
+// when the queue is empty.
 func (c *cliUI) handlePrompt() {
-	shortcut := c.getShortcutInput()
+	defer c.closeOnPanic()
+	for c.prompt() {
+		c.decideInput(c.getShortcutInput())
+	}
+}
+
+// Decides on the request that was shown in the prompt with the policy of the shortcut. The request is denied if the
+// shortcut has no policy, the prompt keeps running.
+func (c *cliUI) decideInput(shortcut string) {
 	policy, err := policies.ByShortcut(shortcut)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Denied the request, the input %q could not be read as a decision: %v", shortcut, err)
+		policy = policies.Deny()
 	}
+	c.decidePrompted(policy)
+}
+
+// Remembers the request that is shown in the prompt, the next input decides on it. Returns false if the queue is
+// empty, then the prompt stops.
+func (c *cliUI) prompt() bool {
 	c.Lock()
 	defer c.Unlock()
-	c.queue[0].Res <- policy // Send the policy to the response channel
-	err = os.Remove(c.queue[0].File.Name())
-	if err != nil {
-		log.Fatal(err)
+	if len(c.queue) == 0 { // The queue is empty when the requests were withdrawn or denied when the UI was closed
+		c.prompting = false
+		return false
 	}
-	c.queue = c.queue[1:] // Pop
-	if len(c.queue) > 0 {
-		c.render()
-		go c.updateDisplayedPrompt()
+	c.prompted = c.queue[0].Id
+	return true
+}
+
+// Responds to the request that was shown in the prompt with the policy and renders the next one. The input is
+// discarded if that request was withdrawn while the user was typing, then the next request is shown instead.
+func (c *cliUI) decidePrompted(policy policies.Policy) {
+	c.Lock()
+	defer c.Unlock()
+	if i := c.indexOf(c.prompted); i >= 0 {
+		c.queue[i].respond(policy)
+		c.remove(i)
 	}
+	c.render()
 }
 
 // Re-renders the complete command line interface view.
 func (c *cliUI) render() {
 	fmt.Fprintf(c.App.Writer, "%s%s", cli.ClearScreen.String(), cli.CursorTo(1, 1))
-	c.writeQueue()
-	c.writeHeader()
+	if c.expanded && len(c.queue) > 0 {
+		c.writeHeader()
+		c.writeDetails()
+	} else {
+		c.writeQueue()
+		c.writeHeader()
+	}
 	c.writePrompt()
 }
 
 // Wait for the user to enter an input and return it. It will retry and re-render if the input is not a shortcut.
+// If the input can not be read (eg. it is closed), the request is denied.
 func (c *cliUI) getShortcutInput() string {
-	var in string
 	for {
-		in = c.App.GetInput()
+		in, err := c.App.GetInput(c.ctx)
+		if c.ctx.Err() != nil {
+			return policies.Deny().Shortcut() // The pending requests were denied when the UI was closed
+		}
+		if err != nil {
+			fmt.Fprintf(c.App.Writer, "\n%s\n", cli.RedColor.Format(fmt.Sprintf("Could not read your decision (%v), denied the request", err)))
+			return policies.Deny().Shortcut()
+		}
+		in = strings.TrimSpace(in)
 		if policies.ShortcutValid(in) {
 			return in
 		}
@@ -226,9 +644,13 @@ func (c *cliUI) getShortcutInput() string {
 
 // Maps the policies to a color for display purposes.
 var colorMap = map[policies.Policy]cli.Color{
-	policies.Allow():       cli.GreenColor,
-	policies.AllowAlways(): cli.GreenColor,
-	policies.Deny():        cli.RedColor,
+	policies.Allow():             cli.GreenColor,
+	policies.AllowFor15Minutes(): cli.GreenColor,
+	policies.AllowUntilRestart(): cli.GreenColor,
+	policies.AllowAlways():       cli.GreenColor,
+	policies.AllowSimilar():      cli.BlueColor,
+	policies.Deny():              cli.RedColor,
+	policies.DenyAlways():        cli.MagentaColor,
 }
 
 // Gets the colorized decision string of a policy. This is used in the prompt, eg. "Allow (a)" or "Deny (d)".
@@ -26,10 +26,18 @@ package ui
 
 import (
 	"bytes"
+	"context"
 	"github.com/tcorp-bv/backstage-hook/actions"
 	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 //Todo: Test that no ANSI escape codes can be injected
@@ -50,3 +58,245 @@ func TestPromptHeight(t *testing.T) {
 	}
 
 }
+
+func TestPairingPromptHeight(t *testing.T) {
+	var buf bytes.Buffer
+	ui := cliUI{App: &cli.App{Writer: &buf}}
+	ui.queue = []requestResponse{{Pairing: "123456"}}
+
+	ui.writePrompt()
+
+	lines := strings.Count(buf.String(), "\n") + 1
+	if lines != promptHeight {
+		t.Error("Pairing prompt is ", lines, " lines, expected ", promptHeight, " lines.")
+	}
+	if !strings.Contains(buf.String(), "123456") {
+		t.Error("Pairing prompt does not show the code")
+	}
+}
+
+// Makes sure that the command file and the prompt show the executable that runs the command and its context.
+func TestExecutableShown(t *testing.T) {
+	var buf bytes.Buffer
+	ui := cliUI{App: &cli.App{Writer: &buf}}
+	exe := &actions.Executable{Path: "/usr/bin/git", Digest: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+	command := actions.Command{Name: "git", Args: []string{"status"}, Dir: "/home/user/src", Env: map[string]string{"GIT_PAGER": "cat"}, Stdin: "some input"}
+	ui.queue = []requestResponse{{Req: actions.Action{Command: command, Plugin: "testplugin", Executable: exe, PassEnv: []string{"HOME", "PATH"}}}}
+
+	ui.writePrompt()
+	defer os.Remove(ui.queue[0].File.Name())
+
+	if !strings.Contains(buf.String(), exe.Path) || !strings.Contains(buf.String(), exe.Digest[:12]) {
+		t.Error("Prompt does not show the executable: ", buf.String())
+	}
+	if !strings.Contains(buf.String(), `In "/home/user/src", sets GIT_PAGER, 10 bytes of input`) {
+		t.Error("Prompt does not show the context: ", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Passes HOME, PATH from your environment") {
+		t.Error("Prompt does not show the passed variables: ", buf.String())
+	}
+	data, err := ioutil.ReadFile(ui.queue[0].File.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "git status") || !strings.Contains(string(data), exe.Path) || !strings.Contains(string(data), exe.Digest) ||
+		!strings.Contains(string(data), "GIT_PAGER=cat") || !strings.Contains(string(data), "some input") ||
+		!strings.Contains(string(data), "Passes HOME, PATH") {
+		t.Error("Command file does not show the executable: ", string(data))
+	}
+}
+
+// Makes sure that a request is denied instead of crashing the hook when the input is closed.
+func TestShortcutInputClosed(t *testing.T) {
+	var buf bytes.Buffer
+	ui := NewCli(&cli.App{Reader: strings.NewReader("q\n  a \n"), Writer: &buf}).(*cliUI)
+	if in := ui.getShortcutInput(); in != policies.Allow().Shortcut() {
+		t.Error("expected the allow shortcut, got ", in)
+	}
+	if in := ui.getShortcutInput(); in != policies.Deny().Shortcut() {
+		t.Error("expected the deny shortcut after the input closed, got ", in)
+	}
+	if !strings.Contains(buf.String(), "denied the request") {
+		t.Error("the user is not told that the request was denied: ", buf.String())
+	}
+}
+
+// Makes sure that in raw mode the arrow keys select a request, a single key decides on it, enter shows its details and
+// Ctrl-C denies the other requests and restores the terminal.
+func TestRawModeKeys(t *testing.T) {
+	var buf bytes.Buffer
+	ui := NewCli(&cli.App{Reader: strings.NewReader("\x1b[B\x1b[Bx\x1b[A\rq\x03"), Writer: &buf}).(*cliUI)
+	restored := false
+	ui.restore = func() error {
+		restored = true
+		return nil
+	}
+	interrupted := make(chan struct{})
+	ui.interrupt = func() { close(interrupted) }
+	var results []chan policies.Policy
+	for _, name := range []string{"ls", "git", "rm"} {
+		res := make(chan policies.Policy, 1)
+		ui.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: name, Args: []string{"-x"}}}, res)
+		results = append(results, res)
+	}
+
+	go ui.readKeys()
+	<-interrupted
+	expected := []policies.Policy{policies.Deny(), policies.Deny(), policies.DenyAlways()}
+	for i, res := range results {
+		if p := <-res; p != expected[i] {
+			t.Errorf("expected %s for request %d, got %s", expected[i].Name(), i+1, p.Name())
+		}
+	}
+	if !restored {
+		t.Error("the terminal was not restored")
+	}
+	if !strings.Contains(buf.String(), "DETAILS of request 1") || !strings.Contains(buf.String(), `Argument 1:    "-x"`) {
+		t.Error("the details were not shown: ", buf.String())
+	}
+	if !strings.Contains(buf.String(), "3. NEW REQUEST") {
+		t.Error("the last request was not selected: ", buf.String())
+	}
+
+	res := make(chan policies.Policy, 1)
+	ui.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}}, res)
+	if p := <-res; p != policies.Deny() {
+		t.Error("request after closing was not denied: ", p.Name())
+	}
+}
+
+// Makes sure that in raw mode a key meant for a withdrawn request does not decide on the request shown in its place.
+func TestRawModeWithdrawSelected(t *testing.T) {
+	ui := NewCli(&cli.App{Writer: ioutil.Discard}).(*cliUI)
+	ui.restore = func() error { return nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	first, withdrawn, last := make(chan policies.Policy, 1), make(chan policies.Policy, 1), make(chan policies.Policy, 1)
+	ui.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}}, first)
+	ui.Handle(ctx, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "git"}}, withdrawn)
+	ui.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "rm"}}, last)
+	ui.handleKey(cli.KeyDown)
+
+	ui.withdraw(2)
+	ui.handleKey(cli.Key('a'))
+	select {
+	case p := <-first:
+		t.Error("the key for the withdrawn request decided on the first request: ", p.Name())
+	case p := <-last:
+		t.Error("the key for the withdrawn request decided on the last request: ", p.Name())
+	default:
+	}
+	ui.handleKey(cli.Key('a'))
+	if p := <-last; p != policies.Allow() {
+		t.Error("expected the shown request to be allowed, got ", p.Name())
+	}
+	cancel()
+}
+
+// Makes sure that a withdrawn request is removed from the queue, and that input typed while the request was shown is
+// discarded instead of deciding on the next request.
+func TestWithdraw(t *testing.T) {
+	r, w := io.Pipe()
+	ui := NewCli(&cli.App{Reader: r, Writer: ioutil.Discard}).(*cliUI)
+	ctx, cancel := context.WithCancel(context.Background())
+	withdrawn, next := make(chan policies.Policy, 1), make(chan policies.Policy, 1)
+	ui.Handle(ctx, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}}, withdrawn)
+	ui.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "rm"}}, next)
+	waitPrompted(ui, 1)
+
+	cancel()
+	for queued := 2; queued != 1; {
+		time.Sleep(time.Millisecond)
+		ui.Lock()
+		queued = len(ui.queue)
+		ui.Unlock()
+	}
+	if _, err := w.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitPrompted(ui, 2)
+	select {
+	case p := <-next:
+		t.Error("the input for the withdrawn request decided on the next request: ", p.Name())
+	default:
+	}
+	if _, err := w.Write([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-next; p != policies.Allow() {
+		t.Error("expected the next request to be allowed, got ", p.Name())
+	}
+	select {
+	case p := <-withdrawn:
+		t.Error("withdrawn request was decided: ", p.Name())
+	default:
+	}
+}
+
+// Waits until the prompt reads the input for the request with the id.
+func waitPrompted(ui *cliUI, id int) {
+	for prompted := 0; prompted != id; {
+		time.Sleep(time.Millisecond)
+		ui.Lock()
+		prompted = ui.prompted
+		ui.Unlock()
+	}
+}
+
+// Makes sure that input without a policy denies the shown request instead of stopping the hook.
+func TestDecideInputUnknown(t *testing.T) {
+	ui := NewCli(&cli.App{Writer: ioutil.Discard}).(*cliUI)
+	res := make(chan policies.Policy, 1)
+	ui.Lock()
+	id := ui.add(requestResponse{Req: actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}}, Res: res})
+	ui.prompted = id
+	ui.Unlock()
+
+	ui.decideInput("unknown")
+	if p := <-res; p != policies.Deny() {
+		t.Error("expected the request to be denied, got ", p.Name())
+	}
+	if len(ui.queue) != 0 || ui.closed {
+		t.Error("the prompt did not keep running after the decision")
+	}
+}
+
+// Makes sure that a request is denied if its command can not be stored for the user to view.
+func TestHandleFileNotStored(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the temporary directory is not set by TMPDIR")
+	}
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", filepath.Join(os.TempDir(), "nonexistent-backstage-hook-test"))
+
+	ui := NewCli(&cli.App{Writer: ioutil.Discard}).(*cliUI)
+	res := make(chan policies.Policy, 1)
+	ui.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}}, res)
+	if p := <-res; p != policies.Deny() {
+		t.Error("expected the request to be denied, got ", p.Name())
+	}
+	if len(ui.queue) != 0 {
+		t.Error("denied request is still queued")
+	}
+}
+
+// Makes sure that the terminal is restored when a goroutine of the UI panics, and that the panic continues.
+func TestCloseOnPanic(t *testing.T) {
+	restored := false
+	ui := NewCli(&cli.App{Writer: ioutil.Discard}).(*cliUI)
+	ui.restore = func() error {
+		restored = true
+		return nil
+	}
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		defer ui.closeOnPanic()
+		panic("test panic")
+	}()
+	if recovered != "test panic" {
+		t.Error("the panic did not continue, recovered ", recovered)
+	}
+	if !restored {
+		t.Error("the terminal was not restored")
+	}
+}
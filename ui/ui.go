@@ -25,12 +25,40 @@
 package ui
 
 import (
+	"context"
 	"github.com/tcorp-bv/backstage-hook/actions"
 	"github.com/tcorp-bv/backstage-hook/policies"
+	"time"
 )
 
-// Frontend to approve actions (commands). Currently this is implemented by cliUI but a web UI may also be implemented.
+// Frontend to approve actions (commands). This is implemented by cliUI for the terminal and webUI for the browser.
 type UI interface {
-	Handle(req actions.Action, res chan policies.Policy)
+	// Asks the user to decide on the action, the decision is sent to res. The request is withdrawn when ctx is done
+	// before the user decided, eg. when the plugin cancelled the action.
+	Handle(ctx context.Context, req actions.Action, res chan policies.Policy)
+	// Asks the user to confirm that Backstage shows the same pairing code, true is sent to res if the user confirms.
+	// The request is withdrawn when ctx is done before the user decided.
+	Pair(ctx context.Context, code string, res chan bool)
 	Setup()
+	// Denies the requests that are still pending and undoes what Setup changed, eg. the mode of the terminal. Later
+	// requests are denied without asking.
+	Close()
+}
+
+// An approved action that is being executed.
+type Execution struct {
+	// The id that the hook assigned to the action request
+	Id string `json:"id"`
+	// The action that is executed
+	Action actions.Action `json:"action"`
+	// The time the execution started
+	Started time.Time `json:"started"`
+}
+
+// Lists and cancels the actions that are being executed, this is implemented by the server.
+type Executions interface {
+	// Returns the actions that are being executed, in the order they were started
+	Running() []Execution
+	// Kills the command of the action with the given id, returns false if no such action is being executed
+	Cancel(id string) bool
 }
@@ -0,0 +1,160 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ui
+
+// The single page of the web UI. All values are rendered with textContent, so nothing in a request can inject markup.
+const webPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>backstage-hook</title>
+<style>
+	body { font-family: sans-serif; margin: 2em; color: #222; }
+	.request { border: 1px solid #ccc; border-radius: 4px; padding: 1em; margin-bottom: 1em; }
+	.request h2 { font-size: 1.1em; margin: 0 0 .5em 0; }
+	code, pre { background: #f4f4f4; padding: .2em .4em; white-space: pre-wrap; word-break: break-all; }
+	ol { margin: .5em 0; }
+	button { margin-right: .5em; padding: .4em 1em; cursor: pointer; }
+	button.ALLOW, button.ALLOW_15M, button.ALLOW_UNTIL_RESTART, button.ALLOW_ALWAYS { background: #2e7d32; color: white; }
+	button.ALLOW_SIMILAR { background: #1565c0; color: white; }
+	button.DENY { background: #c62828; color: white; }
+	button.DENY_ALWAYS { background: #8e24aa; color: white; }
+	.empty { color: #777; }
+</style>
+</head>
+<body>
+<h1>backstage-hook</h1>
+<div id="queue"><p class="empty">Loading...</p></div>
+<h1>Running</h1>
+<div id="running"></div>
+<script>
+	const params = new URLSearchParams(window.location.search);
+	const token = params.get("token");
+	let rendered = "";
+
+	function element(tag, text, className) {
+		const e = document.createElement(tag);
+		if (text !== undefined) e.textContent = text;
+		if (className) e.className = className;
+		return e;
+	}
+
+	async function post(path, body) {
+		await fetch(path, {
+			method: "POST",
+			headers: {"Content-Type": "application/json", "X-Backstage-Hook-Token": token},
+			body: JSON.stringify(body),
+		});
+		refresh();
+	}
+
+	function decide(id, policy) {
+		return post("decide", {id: id, policy: policy});
+	}
+
+	function renderExecution(e) {
+		const div = element("div", undefined, "request");
+		div.appendChild(element("h2", "Running for " + JSON.stringify(e.action.plugin) + " since " + new Date(e.started).toLocaleTimeString()));
+		div.appendChild(element("pre", [e.action.command.name].concat(e.action.command.args || []).map(a => JSON.stringify(a)).join(" ")));
+		const button = element("button", "Cancel", "DENY");
+		button.title = "Kill the command and the processes it started";
+		button.onclick = () => post("cancel", {id: e.id});
+		div.appendChild(button);
+		return div;
+	}
+
+	function renderRequest(req, policies) {
+		const div = element("div", undefined, "request");
+		if (req.pairing) {
+			div.appendChild(element("h2", "Pairing request from Backstage"));
+			div.appendChild(element("p", "Only allow if Backstage shows this code and you started pairing:"));
+			div.appendChild(element("pre", req.pairing));
+			policies = policies.filter(p => p.id === "ALLOW" || p.id === "DENY");
+		} else {
+			div.appendChild(element("h2", "Request by " + JSON.stringify(req.plugin)));
+			div.appendChild(element("p", "Command:"));
+			div.appendChild(element("pre", JSON.stringify(req.command.name)));
+			const args = element("ol");
+			(req.command.args || []).forEach(a => args.appendChild(element("li")).appendChild(element("code", JSON.stringify(a))));
+			div.appendChild(element("p", "Arguments:"));
+			div.appendChild(args);
+			div.appendChild(element("p", "Directory:"));
+			div.appendChild(element("pre", req.command.dir ? JSON.stringify(req.command.dir) : "the directory of the hook"));
+			const env = Object.keys(req.command.env || {}).sort();
+			if (env.length > 0) {
+				const vars = element("ul");
+				env.forEach(name => vars.appendChild(element("li")).appendChild(element("code", name + "=" + JSON.stringify(req.command.env[name]))));
+				div.appendChild(element("p", "Environment:"));
+				div.appendChild(vars);
+			}
+			div.appendChild(element("p", "Passed from your environment: " + (req.passEnv && req.passEnv.length > 0 ? req.passEnv.join(", ") : "nothing")));
+			if (req.command.stdin) {
+				div.appendChild(element("p", "Input:"));
+				div.appendChild(element("pre", req.command.stdin));
+			}
+			if (req.executable) {
+				div.appendChild(element("p", "Executable:"));
+				div.appendChild(element("pre", JSON.stringify(req.executable.path) + (req.executable.digest ? "\nsha256 " + req.executable.digest : "")));
+			}
+			div.appendChild(element("p", "Allow similar will " + req.similar));
+			div.appendChild(element("p", "Hash: " + req.hash));
+		}
+		policies.forEach(p => {
+			const button = element("button", p.name, p.id);
+			button.title = p.description;
+			button.onclick = () => decide(req.id, p.id);
+			div.appendChild(button);
+		});
+		return div;
+	}
+
+	async function refresh() {
+		const queue = document.getElementById("queue");
+		const running = document.getElementById("running");
+		try {
+			const res = await fetch("queue", {headers: {"X-Backstage-Hook-Token": token}});
+			if (!res.ok) throw new Error(res.statusText);
+			const data = await res.json();
+			const json = JSON.stringify(data);
+			if (json === rendered) return; // Do not move the buttons while the user is about to click
+			rendered = json;
+			queue.replaceChildren();
+			if (data.requests.length === 0) queue.appendChild(element("p", "No actions are waiting for your approval.", "empty"));
+			data.requests.forEach(req => queue.appendChild(renderRequest(req, data.policies)));
+			running.replaceChildren();
+			if (data.running.length === 0) running.appendChild(element("p", "No actions are running.", "empty"));
+			data.running.forEach(e => running.appendChild(renderExecution(e)));
+		} catch (e) {
+			rendered = "";
+			queue.replaceChildren(element("p", "Could not reach the hook: " + e.message, "empty"));
+		}
+	}
+
+	refresh();
+	setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,342 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ui
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"github.com/tcorp-bv/backstage-hook/rules"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// The path under which the web UI must be served
+	WebPath = "/ui/"
+	// Header that carries the launch token on every API request of the web UI
+	TokenHeader = "X-Backstage-Hook-Token"
+)
+
+// A UI that is served to the browser, it must be served under WebPath.
+type WebUI interface {
+	UI
+	http.Handler
+	// Shows the running actions of e in the browser, so the user can cancel them
+	SetExecutions(e Executions)
+}
+
+// A pending action or pairing request as shown in the browser.
+type webRequest struct {
+	// Identifies the request when deciding
+	Id string `json:"id"`
+	// The plugin that requests the action, empty for pairing requests
+	Plugin string `json:"plugin,omitempty"`
+	// The full command, the arguments are shown separately so nothing can hide in a long command line
+	Command *actions.Command `json:"command,omitempty"`
+	// The executable that runs the command
+	Executable *actions.Executable `json:"executable,omitempty"`
+	// The variables of the environment of the hook that are passed to the command
+	PassEnv []string `json:"passEnv,omitempty"`
+	// The hash of the action
+	Hash string `json:"hash,omitempty"`
+	// The rule that is created when choosing AllowSimilar
+	Similar string `json:"similar,omitempty"`
+	// The pairing code, only set for pairing requests
+	Pairing string `json:"pairing,omitempty"`
+
+	res     chan policies.Policy
+	confirm chan bool
+}
+
+// Denies the request, or rejects the pairing code.
+func (r *webRequest) deny() {
+	if r.Pairing != "" {
+		r.confirm <- false
+	} else {
+		r.res <- policies.Deny()
+	}
+}
+
+// A decision the user can make in the browser.
+type webPolicy struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// The queue as sent to the browser.
+type webQueue struct {
+	Requests []*webRequest `json:"requests"`
+	Policies []webPolicy   `json:"policies"`
+	Running  []Execution   `json:"running"`
+}
+
+// A decision sent by the browser.
+type webDecision struct {
+	Id     string `json:"id"`
+	Policy string `json:"policy"`
+}
+
+// A cancellation sent by the browser.
+type webCancel struct {
+	Id string `json:"id"`
+}
+
+// Creates the browser frontend for the hook. baseURL is the url on which the returned handler is served, eg.
+// http://127.0.0.1:7077. Every launch generates a new token that is required for all requests, so other pages
+// in the browser can not decide on actions.
+func NewWeb(app *cli.App, baseURL string) (WebUI, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	w := &webUI{App: app, baseURL: baseURL, token: base64.RawURLEncoding.EncodeToString(token), pending: map[string]*webRequest{}}
+	w.mux = http.NewServeMux()
+	w.mux.HandleFunc(WebPath, w.servePage)
+	w.mux.HandleFunc(WebPath+"queue", w.serveQueue)
+	w.mux.HandleFunc(WebPath+"decide", w.serveDecide)
+	w.mux.HandleFunc(WebPath+"cancel", w.serveCancel)
+	return w, nil
+}
+
+// Browser frontend for backstage-hook.
+type webUI struct {
+	sync.Mutex
+	App     *cli.App
+	baseURL string
+	token   string
+	mux     *http.ServeMux
+	// Pending requests by id, in order of arrival
+	pending map[string]*webRequest
+	order   []string
+	// Used to generate request ids
+	nextId int
+	// The running actions, nil until SetExecutions is called
+	executions Executions
+	// Whether the UI is closed, requests are denied without asking
+	closed bool
+}
+
+// Tells the user where to open the web UI.
+func (w *webUI) Setup() {
+	fmt.Fprintf(w.App.Writer, "%s\n", cli.YellowColor.Format(w.App.Name))
+	fmt.Fprintf(w.App.Writer, "Open %s%s?token=%s to approve actions\n", w.baseURL, WebPath, w.token)
+}
+
+// Denies the pending requests, see the UI interface.
+func (w *webUI) Close() {
+	w.Lock()
+	defer w.Unlock()
+	w.closed = true
+	for _, id := range w.order {
+		w.pending[id].deny()
+	}
+	w.pending = map[string]*webRequest{}
+	w.order = nil
+}
+
+// See the WebUI interface.
+func (w *webUI) SetExecutions(e Executions) {
+	w.Lock()
+	defer w.Unlock()
+	w.executions = e
+}
+
+// Adds the action to the queue shown in the browser.
+func (w *webUI) Handle(ctx context.Context, req actions.Action, res chan policies.Policy) {
+	command := req.Command
+	w.add(ctx, &webRequest{Plugin: req.Plugin, Command: &command, Executable: req.Executable, PassEnv: req.PassEnv, Hash: req.Hash(), Similar: rules.FromAction(req, rules.Allow).String(), res: res})
+	fmt.Fprintf(w.App.Writer, "New request by %q waiting for your approval in the browser\n", req.Plugin)
+}
+
+// Adds the pairing request to the queue shown in the browser.
+func (w *webUI) Pair(ctx context.Context, code string, res chan bool) {
+	w.add(ctx, &webRequest{Pairing: code, confirm: res})
+	fmt.Fprintf(w.App.Writer, "Pairing request waiting for your approval in the browser\n")
+}
+
+// Adds a request to the queue, or denies it if the UI is closed. The request is removed when ctx is done.
+func (w *webUI) add(ctx context.Context, r *webRequest) {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		r.deny()
+		return
+	}
+	w.nextId++
+	r.Id = strconv.Itoa(w.nextId)
+	w.pending[r.Id] = r
+	w.order = append(w.order, r.Id)
+	go func() {
+		<-ctx.Done()
+		w.take(r.Id)
+	}()
+}
+
+// Removes the request with id from the queue, returns nil if there is no such request.
+func (w *webUI) take(id string) *webRequest {
+	w.Lock()
+	defer w.Unlock()
+	r := w.pending[id]
+	delete(w.pending, id)
+	for i, pendingId := range w.order {
+		if pendingId == id {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			break
+		}
+	}
+	return r
+}
+
+// Serves the web UI after checking that the request is meant for this hook.
+func (w *webUI) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r.Host) { // Protects against DNS rebinding
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	}
+	rw.Header().Set("X-Frame-Options", "DENY") // Other pages can not trick the user into clicking in a frame
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'; frame-ancestors 'none'")
+	rw.Header().Set("Referrer-Policy", "no-referrer") // The url contains the token
+	w.mux.ServeHTTP(rw, r)
+}
+
+// Serves the page, the token must be in the query.
+func (w *webUI) servePage(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != WebPath || !w.validToken(r.URL.Query().Get("token")) {
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(rw, webPage)
+}
+
+// Serves the pending requests and the available decisions.
+func (w *webUI) serveQueue(rw http.ResponseWriter, r *http.Request) {
+	if !w.validToken(r.Header.Get(TokenHeader)) {
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	}
+	queue := webQueue{Requests: []*webRequest{}, Running: []Execution{}}
+	for _, p := range policies.All() {
+		queue.Policies = append(queue.Policies, webPolicy{Id: p.Id(), Name: p.Name(), Description: p.Description()})
+	}
+	w.Lock()
+	for _, id := range w.order {
+		queue.Requests = append(queue.Requests, w.pending[id])
+	}
+	executions := w.executions
+	w.Unlock()
+	if executions != nil {
+		queue.Running = executions.Running()
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(queue)
+}
+
+// Sends the decision of the user to the request.
+func (w *webUI) serveDecide(rw http.ResponseWriter, r *http.Request) {
+	var d webDecision
+	if !w.readPost(rw, r, &d) {
+		return
+	}
+	p, err := policies.ById(d.Policy)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := w.take(d.Id)
+	if req == nil {
+		http.Error(rw, "request not found", http.StatusNotFound)
+		return
+	}
+	if req.Pairing != "" {
+		req.confirm <- p.Allows()
+	} else {
+		req.res <- p
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Cancels a running action.
+func (w *webUI) serveCancel(rw http.ResponseWriter, r *http.Request) {
+	var c webCancel
+	if !w.readPost(rw, r, &c) {
+		return
+	}
+	w.Lock()
+	executions := w.executions
+	w.Unlock()
+	if executions == nil || !executions.Cancel(c.Id) {
+		http.Error(rw, "action not found", http.StatusNotFound)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Decodes the JSON body of a POST request with a valid token into v. If the request is invalid, an error response is
+// written and false is returned.
+func (w *webUI) readPost(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !w.validToken(r.Header.Get(TokenHeader)) {
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// Checks the token in constant time.
+func (w *webUI) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(w.token)) == 1
+}
+
+// Whether the host (with optional port) of a request is a loopback address or localhost.
+func isLoopback(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,223 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2020 TCorp BV
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ui
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/tcorp-bv/backstage-hook/actions"
+	"github.com/tcorp-bv/backstage-hook/cli"
+	"github.com/tcorp-bv/backstage-hook/policies"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates a web UI and returns it with its token.
+func newTestWebUI(t *testing.T) (*webUI, string) {
+	w, err := NewWeb(&cli.App{Name: "test", Writer: ioutil.Discard}, "http://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.(*webUI), w.(*webUI).token
+}
+
+// Sends a request to the web UI and returns the recorded response.
+func webRequestTo(w *webUI, method string, target string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Host = "127.0.0.1:7077"
+	if token != "" {
+		req.Header.Set(TokenHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	return rec
+}
+
+// Makes sure that nothing is served without the launch token.
+func TestWebToken(t *testing.T) {
+	w, token := newTestWebUI(t)
+	if rec := webRequestTo(w, http.MethodGet, WebPath+"?token="+token, "", ""); rec.Code != http.StatusOK {
+		t.Error("page was not served with a valid token: ", rec.Code)
+	}
+	forbidden := []*httptest.ResponseRecorder{
+		webRequestTo(w, http.MethodGet, WebPath, "", ""),
+		webRequestTo(w, http.MethodGet, WebPath+"?token=wrong", "", ""),
+		webRequestTo(w, http.MethodGet, WebPath+"queue", "wrong", ""),
+		webRequestTo(w, http.MethodPost, WebPath+"decide", "", `{"id":"1","policy":"ALLOW"}`),
+	}
+	for i, rec := range forbidden {
+		if rec.Code != http.StatusForbidden {
+			t.Error("request ", i, " without a valid token was not forbidden: ", rec.Code)
+		}
+	}
+
+	// Requests for other hosts are rejected to protect against DNS rebinding
+	req := httptest.NewRequest(http.MethodGet, WebPath+"?token="+token, nil)
+	req.Host = "evil.example.com"
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Error("request for another host was not forbidden")
+	}
+}
+
+// Makes sure that queued actions are listed with their full command and can be decided.
+func TestWebDecide(t *testing.T) {
+	w, token := newTestWebUI(t)
+	res := make(chan policies.Policy, 1)
+	a := actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "git", Args: []string{"status", "-s"}}}
+	w.Handle(context.Background(), a, res)
+
+	rec := webRequestTo(w, http.MethodGet, WebPath+"queue", token, "")
+	var queue webQueue
+	if err := json.NewDecoder(rec.Body).Decode(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Requests) != 1 || len(queue.Policies) != len(policies.All()) {
+		t.Fatal("unexpected queue: ", queue)
+	}
+	req := queue.Requests[0]
+	if req.Plugin != "testplugin" || req.Command.String() != a.Command.String() || req.Hash != a.Hash() {
+		t.Error("queued request does not show the full action: ", req)
+	}
+
+	body, _ := json.Marshal(webDecision{Id: req.Id, Policy: policies.AllowAlways().Id()})
+	if rec := webRequestTo(w, http.MethodPost, WebPath+"decide", token, string(body)); rec.Code != http.StatusNoContent {
+		t.Fatal("decision was rejected: ", rec.Code)
+	}
+	if p := <-res; p != policies.AllowAlways() {
+		t.Error("decision was not sent to the response channel")
+	}
+	if rec := webRequestTo(w, http.MethodPost, WebPath+"decide", token, string(body)); rec.Code != http.StatusNotFound {
+		t.Error("a request can only be decided once")
+	}
+}
+
+// Makes sure that a request is removed from the queue when it is withdrawn.
+func TestWebWithdraw(t *testing.T) {
+	w, token := newTestWebUI(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	w.Handle(ctx, actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "git"}}, make(chan policies.Policy, 1))
+	cancel()
+
+	for i := 0; i < 1000; i++ {
+		rec := webRequestTo(w, http.MethodGet, WebPath+"queue", token, "")
+		var queue webQueue
+		if err := json.NewDecoder(rec.Body).Decode(&queue); err != nil {
+			t.Fatal(err)
+		}
+		if len(queue.Requests) == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("withdrawn request is still queued")
+}
+
+// Makes sure that pairing requests are confirmed through the browser.
+func TestWebPair(t *testing.T) {
+	w, token := newTestWebUI(t)
+	res := make(chan bool, 1)
+	w.Pair(context.Background(), "123456", res)
+
+	rec := webRequestTo(w, http.MethodGet, WebPath+"queue", token, "")
+	if !bytes.Contains(rec.Body.Bytes(), []byte(`"pairing":"123456"`)) {
+		t.Fatal("pairing code is not shown")
+	}
+	if rec := webRequestTo(w, http.MethodPost, WebPath+"decide", token, `{"id":"1","policy":"DENY"}`); rec.Code != http.StatusNoContent {
+		t.Fatal("decision was rejected: ", rec.Code)
+	}
+	if <-res {
+		t.Error("denied pairing was confirmed")
+	}
+}
+
+// Makes sure that closing the web UI denies the pending requests and the requests that arrive later.
+func TestWebClose(t *testing.T) {
+	w, _ := newTestWebUI(t)
+	res := make(chan policies.Policy, 2)
+	confirm := make(chan bool, 1)
+	w.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "git"}}, res)
+	w.Pair(context.Background(), "123456", confirm)
+
+	w.Close()
+	if p := <-res; p != policies.Deny() {
+		t.Error("pending request was not denied: ", p.Name())
+	}
+	if <-confirm {
+		t.Error("pending pairing was confirmed")
+	}
+	w.Handle(context.Background(), actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "ls"}}, res)
+	if p := <-res; p != policies.Deny() {
+		t.Error("request after closing was not denied: ", p.Name())
+	}
+	if len(w.order) != 0 {
+		t.Error("requests are still queued: ", w.order)
+	}
+}
+
+// Executions with a single running action that records whether it was cancelled.
+type fakeExecutions struct {
+	cancelled bool
+}
+
+func (f *fakeExecutions) Running() []Execution {
+	return []Execution{{Id: "running", Action: actions.Action{Plugin: "testplugin", Command: actions.Command{Name: "sleep"}}}}
+}
+
+func (f *fakeExecutions) Cancel(id string) bool {
+	f.cancelled = id == "running"
+	return f.cancelled
+}
+
+// Makes sure that running actions are listed and can be cancelled.
+func TestWebCancel(t *testing.T) {
+	w, token := newTestWebUI(t)
+	if rec := webRequestTo(w, http.MethodPost, WebPath+"cancel", token, `{"id":"running"}`); rec.Code != http.StatusNotFound {
+		t.Error("cancelled without executions: ", rec.Code)
+	}
+	executions := &fakeExecutions{}
+	w.SetExecutions(executions)
+
+	rec := webRequestTo(w, http.MethodGet, WebPath+"queue", token, "")
+	var queue webQueue
+	if err := json.NewDecoder(rec.Body).Decode(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Running) != 1 || queue.Running[0].Id != "running" {
+		t.Fatal("running action is not listed: ", queue.Running)
+	}
+	if rec := webRequestTo(w, http.MethodPost, WebPath+"cancel", "", `{"id":"running"}`); rec.Code != http.StatusForbidden || executions.cancelled {
+		t.Error("cancelled without a token")
+	}
+	if rec := webRequestTo(w, http.MethodPost, WebPath+"cancel", token, `{"id":"running"}`); rec.Code != http.StatusNoContent || !executions.cancelled {
+		t.Error("running action was not cancelled: ", rec.Code)
+	}
+}